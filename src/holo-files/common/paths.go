@@ -29,10 +29,12 @@ var (
 	targetDirectory   string
 	stateDirectory    string
 	resourceDirectory string
+	mock              bool
 )
 
 func init() {
 	targetDirectory = strings.TrimSuffix(os.Getenv("HOLO_ROOT_DIR"), "/")
+	mock = targetDirectory != ""
 	if targetDirectory == "" {
 		targetDirectory = "/"
 	}
@@ -45,6 +47,13 @@ func TargetDirectory() string {
 	return targetDirectory
 }
 
+//IsMock returns true if $HOLO_ROOT_DIR is set, i.e. when running in a test
+//environment. Changes that require root privileges (like changing the
+//ownership of files) are then only printed instead of being executed.
+func IsMock() bool {
+	return mock
+}
+
 //ResourceDirectory is $HOLO_RESOURCE_DIR.
 func ResourceDirectory() string {
 	return resourceDirectory
@@ -59,3 +68,18 @@ func TargetBaseDirectory() string {
 func ProvisionedDirectory() string {
 	return stateDirectory + "/provisioned"
 }
+
+//CreatedDirectory is $HOLO_STATE_DIR/created.
+func CreatedDirectory() string {
+	return stateDirectory + "/created"
+}
+
+//DirectoryMarkerDirectory is $HOLO_STATE_DIR/directories.
+func DirectoryMarkerDirectory() string {
+	return stateDirectory + "/directories"
+}
+
+//VersionsDirectory is $HOLO_STATE_DIR/versions.
+func VersionsDirectory() string {
+	return stateDirectory + "/versions"
+}
@@ -24,6 +24,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 
 	"../common"
@@ -39,50 +40,197 @@ func apply(target *TargetFile, withForce bool) (skipReport bool, err error) {
 	targetPath := target.PathIn(common.TargetDirectory())
 	targetBasePath := target.PathIn(common.TargetBaseDirectory())
 
+	//run the application algorithm in memory
+	reportedTBPath := ""
+	p, err := target.prepareApply(withForce, func(targetPath string) (string, string, error) {
+		//if we don't have a target base yet, the file at targetPath *is* the
+		//targetBase which we have to copy now (or, if the target is created
+		//from scratch, the target base is empty); this must happen before
+		//FindUpdatedTargetBase() moves files around
+		err := target.ensureTargetBase()
+		if err != nil {
+			return "", "", err
+		}
+		updatedTBPath, reported, err := platform.Implementation().FindUpdatedTargetBase(targetPath)
+		reportedTBPath = reported
+		return updatedTBPath, targetPath, err
+	})
+	if p == nil {
+		return false, err
+	}
+
+	if p.Merge != nil {
+		mergeErr := target.recordMergeResult(p.Merge)
+		if mergeErr != nil {
+			return false, mergeErr
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	//don't do anything more if nothing has changed
+	if p.Unchanged {
+		err := target.adoptUpdatedTargetBase(p.UpdatedTBPath)
+		if err == nil && p.UpdatedTBPath != "" {
+			fmt.Printf(">> found updated target base: %s -> %s\n", reportedTBPath, targetBasePath)
+		}
+		//since we did not do anything, don't report this
+		return true, err
+	}
+
+	//the updated target base replaces the current one only after the new
+	//target file has been provisioned (validators may still veto it)
+	if p.UpdatedTBPath != "" {
+		err = target.provisionMerged(p.Buffer, p.ProvisionedBuffer, p.UpdatedTBPath, true)
+		if err == nil {
+			fmt.Printf(">> found updated target base: %s -> %s\n", reportedTBPath, targetBasePath)
+		}
+	} else {
+		err = target.provisionMerged(p.Buffer, p.ProvisionedBuffer, targetBasePath, false)
+	}
+	if err == nil && p.IsMerged() {
+		fmt.Printf(">> merged changes made by user into %s\n", targetPath)
+	}
+	return false, err
+}
+
+//ensureTargetBase takes a copy of the target file as the target base, unless
+//a target base exists already. If there is no target file either (because it
+//is created from scratch), an empty target base is created.
+func (target *TargetFile) ensureTargetBase() error {
+	targetPath := target.PathIn(common.TargetDirectory())
+	targetBasePath := target.PathIn(common.TargetBaseDirectory())
+	if common.IsManageableFile(targetBasePath) {
+		return nil
+	}
+	if !common.IsManageableFile(targetPath) {
+		return target.createTargetBase()
+	}
+
+	targetBaseDir := filepath.Dir(targetBasePath)
+	err := os.MkdirAll(targetBaseDir, 0755)
+	if err != nil {
+		return fmt.Errorf("Cannot create directory %s: %s", targetBaseDir, err.Error())
+	}
+	err = common.CopyFile(targetPath, targetBasePath)
+	if err != nil {
+		return fmt.Errorf("Cannot copy %s to %s: %s", targetPath, targetBasePath, err.Error())
+	}
+	return nil
+}
+
+//applyPlan describes what apply() will do for a target file. It is computed
+//by TargetFile.prepareApply() without changing anything in the file system.
+type applyPlan struct {
+	Metadata *Metadata
+	//Creating is set if the target file will be created from scratch.
+	Creating bool
+	//BasePath is the file that the target file is rendered from: the target
+	//base, the current target file (if there is no target base yet), the
+	//updated target base (if any), or "" for an empty target base.
+	BasePath string
+	//UpdatedTBPath is the path to the updated target base (or "" if there is
+	//none).
+	UpdatedTBPath string
+	//Merge is set if changes made by the user were merged with the updated
+	//target base. If Merge.Conflicts > 0, the target file is not touched (not
+	//even with --force, since the changes made by the user would be lost).
+	Merge *mergeResult
+	//Buffer will be written to the target path, and ProvisionedBuffer to the
+	//provisioned path (they only differ if changes made by the user were
+	//merged).
+	Buffer            *FileBuffer
+	ProvisionedBuffer *FileBuffer
+	//Unchanged is set if the target file is already up to date.
+	Unchanged bool
+}
+
+//IsMerged returns whether changes made by the user were merged successfully.
+func (p *applyPlan) IsMerged() bool {
+	return p.Merge != nil && p.Merge.Conflicts == 0
+}
+
+//updatedTargetBaseFinder locates the updated target base for the given
+//target path (if any), and returns its path (or "") and the path where the
+//current target file can be found. apply() takes a copy of the target base
+//(if necessary) and then uses platform.Impl.FindUpdatedTargetBase() here,
+//which may move files around;
+//plan() and RenderPendingDiff() use the read-only
+//platform.Impl.ProbeUpdatedTargetBase().
+type updatedTargetBaseFinder func(targetPath string) (updatedTBPath, currentTargetPath string, err error)
+
+//prepareApply runs the application algorithm for this target in memory. It
+//is shared by apply(), plan() and RenderPendingDiff(), and contains all steps
+//of the algorithm except for the ones that change the file system (which are
+//performed by apply() only).
+//
+//If apply() would refuse to work on this target, an error is returned. Once
+//the target base has been chosen, the plan is returned together with any
+//error, since apply() takes a copy of the target base (and writes the result
+//of a failed merge for review) in any case.
+func (target *TargetFile) prepareApply(withForce bool, findUpdatedTargetBase updatedTargetBaseFinder) (*applyPlan, error) {
+	//determine the related paths
+	targetPath := target.PathIn(common.TargetDirectory())
+	targetBasePath := target.PathIn(common.TargetBaseDirectory())
+	metadata, err := target.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	p := &applyPlan{Metadata: metadata, BasePath: targetBasePath}
+
 	//step 1: will only apply targets if:
 	//option 1: there is a manageable file in the target location (this target
 	//file is either the target base from the application package or the
 	//product of a previous Apply run)
 	//option 2: the target file was deleted, but we have a target base that we
 	//can start from
+	//option 3: there is neither, but the target may be created from scratch
 	if !common.IsManageableFile(targetPath) {
 		if !common.IsManageableFile(targetBasePath) {
-			return false, errors.New("skipping target: not a manageable file")
-		}
-		if !withForce {
-			return false, errors.New("skipping target: file has been deleted by user (use --force to restore)")
+			if metadata == nil || !metadata.Create {
+				return nil, errors.New("skipping target: not a manageable file")
+			}
+			p.Creating = true
+		} else if !withForce {
+			return nil, needsForceError("skipping target: file has been deleted by user (use --force to restore)")
 		}
 	}
 
 	//step 2: if we don't have a target base yet, the file at targetPath *is*
-	//the targetBase which we have to copy now
-	if !common.IsManageableFile(targetBasePath) {
-		targetBaseDir := filepath.Dir(targetBasePath)
-		err := os.MkdirAll(targetBaseDir, 0755)
-		if err != nil {
-			return false, fmt.Errorf("Cannot create directory %s: %s", targetBaseDir, err.Error())
-		}
-
-		err = common.CopyFile(targetPath, targetBasePath)
-		if err != nil {
-			return false, fmt.Errorf("Cannot copy %s to %s: %s", targetPath, targetBasePath, err.Error())
-		}
+	//the target base (or, if the target is created from scratch, the target
+	//base is empty)
+	if p.Creating {
+		p.BasePath = ""
+	} else if !common.IsManageableFile(targetBasePath) {
+		p.BasePath = targetPath
 	}
 
 	//step 3: check if a system update installed a new version of the stock
 	//configuration
-	updatedTBPath, reportedTBPath, err := platform.Implementation().FindUpdatedTargetBase(targetPath)
+	updatedTBPath, currentTargetPath, err := findUpdatedTargetBase(targetPath)
 	if err != nil {
-		return false, err
+		return p, err
 	}
 	if updatedTBPath != "" {
-		//an updated stock configuration is available at updatedTBPath
-		fmt.Printf(">> found updated target base: %s -> %s", reportedTBPath, targetBasePath)
-		err := common.CopyFile(updatedTBPath, targetBasePath)
-		if err != nil {
-			return false, fmt.Errorf("Cannot copy %s to %s: %s", updatedTBPath, targetBasePath, err.Error())
+		p.UpdatedTBPath = updatedTBPath
+		p.BasePath = updatedTBPath
+		//in merge mode, keep the changes made by the user to the target
+		if metadata != nil && metadata.Merge {
+			result, err := target.prepareMerge(updatedTBPath)
+			if err != nil {
+				return p, err
+			}
+			p.Merge = result
+			if p.IsMerged() {
+				p.Buffer = NewFileBufferFromContents(result.Contents, targetPath)
+				p.ProvisionedBuffer = result.ProvisionedBuffer
+				return p, nil
+			}
+			if result != nil {
+				return p, mergeConflictError(targetPath + ".holomerge")
+			}
 		}
-		_ = os.Remove(updatedTBPath) //this can fail silently
 	}
 
 	//step 4: apply the repo files *if* the version at targetPath is the one
@@ -92,19 +240,206 @@ func apply(target *TargetFile, withForce bool) (skipReport bool, err error) {
 	var lastProvisionedBuffer *FileBuffer
 	lastProvisionedPath := target.PathIn(common.ProvisionedDirectory())
 	if !withForce && common.IsManageableFile(lastProvisionedPath) {
-		targetBuffer, err := NewFileBuffer(targetPath, targetPath)
+		targetBuffer, err := NewFileBuffer(currentTargetPath, targetPath)
 		if err != nil {
-			return false, err
+			return p, err
 		}
 		lastProvisionedBuffer, err = NewFileBuffer(lastProvisionedPath, targetPath)
 		if err != nil {
-			return false, err
+			return p, err
 		}
 		if !targetBuffer.EqualTo(lastProvisionedBuffer) {
-			return false, errors.New("skipping target: file has been modified by user (use --force to overwrite)")
+			return p, needsForceError("skipping target: file has been modified by user (use --force to overwrite)")
+		}
+		drifted, err := metadataDrifted(metadata, currentTargetPath, lastProvisionedPath)
+		if err != nil {
+			return p, err
+		}
+		if drifted {
+			return p, needsForceError("skipping target: file metadata has been modified by user (use --force to overwrite)")
 		}
 	}
 
+	//step 5: apply all the applicable repo files
+	buffer, err := target.render(p.BasePath)
+	if err != nil {
+		return p, err
+	}
+	p.Buffer = buffer
+	p.ProvisionedBuffer = buffer
+
+	//check if anything has changed
+	if !withForce && lastProvisionedBuffer != nil {
+		metadataMatches := true
+		if metadata != nil {
+			metadataMatches, err = metadata.Matches(lastProvisionedPath)
+			if err != nil {
+				return p, err
+			}
+		}
+		p.Unchanged = buffer.EqualTo(lastProvisionedBuffer) && metadataMatches
+	}
+	return p, nil
+}
+
+//adoptUpdatedTargetBase replaces the target base by the updated target base
+//at the given path (if any). The previous target base is kept for
+//TargetFile.Rollback().
+func (target *TargetFile) adoptUpdatedTargetBase(updatedTBPath string) error {
+	if updatedTBPath == "" {
+		return nil
+	}
+	targetBasePath := target.PathIn(common.TargetBaseDirectory())
+
+	//keep the current target base, unless it's identical (e.g. when it has just
+	//been taken from the target file that the package manager replaced)
+	baseBuffer, err := NewFileBuffer(targetBasePath, targetBasePath)
+	if err != nil {
+		return err
+	}
+	updatedBuffer, err := NewFileBuffer(updatedTBPath, targetBasePath)
+	if err != nil {
+		return err
+	}
+	if !baseBuffer.EqualTo(updatedBuffer) {
+		err = target.saveVersion("base", targetBasePath)
+		if err != nil {
+			return err
+		}
+	}
+	err = common.CopyFile(updatedTBPath, targetBasePath)
+	if err != nil {
+		return fmt.Errorf("Cannot copy %s to %s: %s", updatedTBPath, targetBasePath, err.Error())
+	}
+	_ = os.Remove(updatedTBPath) //this can fail silently
+	return nil
+}
+
+//provision writes the given buffer to the target path (and a copy of it to
+//the provisioned path) with the file metadata of the target base, or the file
+//metadata declared in .holometa repo files. The previously provisioned version
+//is kept for TargetFile.Rollback().
+func (target *TargetFile) provision(buffer *FileBuffer, targetBasePath string) error {
+	return target.provisionMerged(buffer, buffer, targetBasePath, false)
+}
+
+//provisionMerged is like provision, but writes a different buffer to the
+//target path than to the provisioned path. This is used when changes made by
+//the user were merged into the target file (see mergeUpdatedTargetBase()):
+//The provisioned copy is what Holo would have provisioned without these
+//changes, so that they are still recognized as changes made by the user.
+//
+//If adoptTargetBase is true, the given targetBasePath is an updated target
+//base that replaces the current target base once the validators have accepted
+//the new target file.
+func (target *TargetFile) provisionMerged(buffer, provisionedBuffer *FileBuffer, targetBasePath string, adoptTargetBase bool) error {
+	targetPath := target.PathIn(common.TargetDirectory())
+	lastProvisionedPath := target.PathIn(common.ProvisionedDirectory())
+	metadata, err := target.Metadata()
+	if err != nil {
+		return err
+	}
+
+	//write the result buffer next to the target location and copy
+	//owners/permissions from target base to target file (or set the declared
+	//ones)
+	newTargetPath := targetPath + ".holonew"
+	err = buffer.Write(newTargetPath)
+	if err != nil {
+		return err
+	}
+	err = applyMetadata(metadata, targetBasePath, newTargetPath)
+	if err != nil {
+		_ = os.Remove(newTargetPath) //this can fail silently
+		return err
+	}
+
+	//give the validators a chance to veto the new target file (nothing else
+	//has been changed at this point; in particular, an updated target base has
+	//not been adopted yet, see apply())
+	err = target.validate(newTargetPath)
+	if err != nil {
+		_ = os.Remove(newTargetPath) //this can fail silently
+		return err
+	}
+	if adoptTargetBase {
+		err = target.adoptUpdatedTargetBase(targetBasePath)
+		if err != nil {
+			_ = os.Remove(newTargetPath) //this can fail silently
+			return err
+		}
+		targetBasePath = target.PathIn(common.TargetBaseDirectory())
+	}
+
+	//keep the previously provisioned version, unless it's identical
+	if common.IsManageableFile(lastProvisionedPath) {
+		lastProvisionedBuffer, err := NewFileBuffer(lastProvisionedPath, targetPath)
+		if err != nil {
+			return err
+		}
+		if !provisionedBuffer.EqualTo(lastProvisionedBuffer) {
+			err = target.saveVersion("provisioned", lastProvisionedPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	//save a copy of the provisioned config file to check for manual
+	//modifications in the next Apply() run
+	provisionedDir := filepath.Dir(lastProvisionedPath)
+	err = os.MkdirAll(provisionedDir, 0755)
+	if err != nil {
+		return fmt.Errorf("Cannot write %s: %s", lastProvisionedPath, err.Error())
+	}
+	err = provisionedBuffer.Write(lastProvisionedPath)
+	if err != nil {
+		return err
+	}
+	err = applyMetadata(metadata, targetBasePath, lastProvisionedPath)
+	if err != nil {
+		return err
+	}
+
+	//move $target.holonew -> $target atomically (to ensure that there is
+	//always a valid file at $target)
+	return os.Rename(newTargetPath, targetPath)
+}
+
+//applyMetadata copies the file metadata from the target base to the given
+//file, then applies the declared file metadata (if any) on top.
+func applyMetadata(metadata *Metadata, targetBasePath, path string) error {
+	err := common.ApplyFilePermissions(targetBasePath, path)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		return nil
+	}
+	return metadata.Apply(path)
+}
+
+//validate runs all validators of this target on the given candidate for the
+//new target file, and returns an error if one of them rejects it.
+func (target *TargetFile) validate(candidatePath string) error {
+	for _, validator := range target.Validators() {
+		cmd := exec.Command(validator.Path(), candidatePath)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("validation by %s failed: %s", validator.Path(), err.Error())
+		}
+	}
+	return nil
+}
+
+//render loads the target base from the given path and applies all repo
+//entries of this target to it. The result is returned without writing it
+//anywhere. An empty path denotes an empty target base.
+func (target *TargetFile) render(targetBasePath string) (*FileBuffer, error) {
+	targetPath := target.PathIn(common.TargetDirectory())
+
 	//check if we can skip any application steps (firstStep = -1 means: start
 	//with loading the target base and apply all steps, firstStep >= 0 means:
 	//start at that application step with an empty buffer)
@@ -119,13 +454,14 @@ func apply(target *TargetFile, withForce bool) (skipReport bool, err error) {
 	//load the target base into a buffer as the start for the application
 	//algorithm, unless it will be discarded by an application step
 	var buffer *FileBuffer
-	if firstStep == -1 {
+	var err error
+	if firstStep == -1 && targetBasePath != "" {
 		buffer, err = NewFileBuffer(targetBasePath, targetPath)
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 	} else {
-		buffer = NewFileBufferFromContents([]byte(nil), targetPath)
+		buffer = NewFileBufferFromContents([]byte{}, targetPath)
 	}
 
 	//apply all the applicable repo files in order (starting from the first one
@@ -136,46 +472,9 @@ func apply(target *TargetFile, withForce bool) (skipReport bool, err error) {
 	for _, repoFile := range repoEntries {
 		buffer, err = GetApplyImpl(repoFile)(buffer)
 		if err != nil {
-			return false, err
-		}
-	}
-
-	//don't do anything more if nothing has changed
-	if !withForce && lastProvisionedBuffer != nil {
-		if buffer.EqualTo(lastProvisionedBuffer) {
-			//since we did not do anything, don't report this
-			return true, nil
+			return nil, err
 		}
 	}
 
-	//save a copy of the provisioned config file to check for manual
-	//modifications in the next Apply() run
-	provisionedDir := filepath.Dir(lastProvisionedPath)
-	err = os.MkdirAll(provisionedDir, 0755)
-	if err != nil {
-		return false, fmt.Errorf("Cannot write %s: %s", lastProvisionedPath, err.Error())
-	}
-	err = buffer.Write(lastProvisionedPath)
-	if err != nil {
-		return false, err
-	}
-	err = common.ApplyFilePermissions(targetBasePath, lastProvisionedPath)
-	if err != nil {
-		return false, err
-	}
-
-	//write the result buffer to the target location and copy
-	//owners/permissions from target base to target file
-	newTargetPath := targetPath + ".holonew"
-	err = buffer.Write(newTargetPath)
-	if err != nil {
-		return false, err
-	}
-	err = common.ApplyFilePermissions(targetBasePath, newTargetPath)
-	if err != nil {
-		return false, err
-	}
-	//move $target.holonew -> $target atomically (to ensure that there is
-	//always a valid file at $target)
-	return false, os.Rename(newTargetPath, targetPath)
+	return buffer, nil
 }
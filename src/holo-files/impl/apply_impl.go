@@ -37,9 +37,16 @@ type ApplyImpl func(*FileBuffer) (*FileBuffer, error)
 //buffer, as part of the `holo apply` algorithm.
 func GetApplyImpl(repoFile RepoFile) ApplyImpl {
 	var impl func(RepoFile, *FileBuffer) (*FileBuffer, error)
-	if repoFile.ApplicationStrategy() == "passthru" {
+	switch repoFile.ApplicationStrategy() {
+	case "passthru":
 		impl = applyScript
-	} else {
+	case "template":
+		impl = applyTemplate
+	case "edit":
+		impl = applyEdit
+	case "patch":
+		impl = applyPatch
+	default:
 		impl = applyFile
 	}
 	return func(fb *FileBuffer) (*FileBuffer, error) {
@@ -0,0 +1,227 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"../../internal/toml"
+	"../common"
+	"../platform"
+)
+
+//Condition restricts repo entries to the hosts that match it. Conditions are
+//declared in .holocondition files, which are TOML files with the optional
+//keys "hostname" (a list of shell globs), "distribution" (a list of
+//distribution IDs, see platform.GetCurrentDistribution()), "architecture" (a
+//list of architecture names, see currentArchitecture()) and "tags" (a list of
+//host tags, see HostTagsPath()).
+//
+//A host matches the condition if it matches at least one value for each of
+//the keys that are given.
+//
+//A condition for a single target is stored next to its repo entries, e.g.
+//"$resource_dir/01-foo/etc/foo.conf.holocondition", and applies to all repo
+//entries for this target in the same disambiguation directory. A condition
+//for a whole disambiguation directory is stored next to it, e.g.
+//"$resource_dir/01-foo.holocondition".
+type Condition struct {
+	Hostname     []string `toml:"hostname"`
+	Distribution []string `toml:"distribution"`
+	Architecture []string `toml:"architecture"`
+	Tags         []string `toml:"tags"`
+}
+
+//HostTagsPath returns the path to the host-local file that lists the tags of
+//this host for use in .holocondition files.
+func HostTagsPath() string {
+	return filepath.Join(common.TargetDirectory(), "etc/holo/tags")
+}
+
+//isConditionFile returns whether the given path refers to a .holocondition
+//file.
+func isConditionFile(path string) bool {
+	return strings.HasSuffix(path, ".holocondition")
+}
+
+//readCondition reads the .holocondition file at the given path.
+func readCondition(conditionPath string) (*Condition, error) {
+	var c Condition
+	md, err := toml.DecodeFile(conditionPath, &c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %s", conditionPath, err.Error())
+	}
+	//a misspelled key would silently make the condition broader than intended
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("cannot read %s: unknown key \"%s\"", conditionPath, undecoded[0].String())
+	}
+	for _, pattern := range c.Hostname {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("cannot read %s: invalid hostname pattern \"%s\"", conditionPath, pattern)
+		}
+	}
+	return &c, nil
+}
+
+//hostFacts describes the current host for the evaluation of conditions. It is
+//only computed once per ScanRepo() run.
+type hostFacts struct {
+	Hostname     string
+	Distribution map[string]bool
+	Architecture map[string]bool
+	Tags         map[string]bool
+}
+
+func collectHostFacts() (*hostFacts, error) {
+	hostname, err := currentHostname()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := readHostTags()
+	if err != nil {
+		return nil, err
+	}
+	return &hostFacts{
+		Hostname:     hostname,
+		Distribution: platform.GetCurrentDistribution(),
+		Architecture: currentArchitecture(),
+		Tags:         tags,
+	}, nil
+}
+
+//Matches returns whether the given host matches this condition.
+func (c *Condition) Matches(host *hostFacts) bool {
+	if len(c.Hostname) > 0 {
+		matched := false
+		for _, pattern := range c.Hostname {
+			ok, _ := path.Match(pattern, host.Hostname) //was validated in readCondition()
+			matched = matched || ok
+		}
+		if !matched {
+			return false
+		}
+	}
+	return matchesAny(c.Distribution, host.Distribution) &&
+		matchesAny(c.Architecture, host.Architecture) &&
+		matchesAny(c.Tags, host.Tags)
+}
+
+//matchesAny returns true if no values are given, or if at least one of them
+//is in the given set.
+func matchesAny(values []string, set map[string]bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if set[value] {
+			return true
+		}
+	}
+	return false
+}
+
+//currentArchitecture returns the names of the architecture of this system:
+//both the machine name reported by uname(2) (e.g. "x86_64") and the name used
+//by Go (e.g. "amd64"). If $HOLO_CURRENT_ARCHITECTURE is set (for unit tests),
+//its value is returned instead.
+func currentArchitecture() map[string]bool {
+	if value := os.Getenv("HOLO_CURRENT_ARCHITECTURE"); value != "" {
+		return map[string]bool{value: true}
+	}
+
+	result := map[string]bool{runtime.GOARCH: true}
+	var uts syscall.Utsname
+	if syscall.Uname(&uts) == nil {
+		//(the element type of Machine differs between architectures)
+		var machine []byte
+		for _, c := range uts.Machine {
+			if c == 0 {
+				break
+			}
+			machine = append(machine, byte(c))
+		}
+		result[string(machine)] = true
+	}
+	return result
+}
+
+//readHostTags reads the host-local tags file. It contains tags separated by
+//whitespace; everything after a "#" on a line is a comment. If the file does
+//not exist, the host has no tags.
+func readHostTags() (map[string]bool, error) {
+	tags := make(map[string]bool)
+	contents, err := ioutil.ReadFile(HostTagsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tags, nil
+		}
+		return nil, err
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		for _, tag := range strings.Fields(line) {
+			tags[tag] = true
+		}
+	}
+	return tags, nil
+}
+
+//conditionChecker evaluates the .holocondition files in the repo. Results are
+//cached since conditions apply to multiple repo entries.
+type conditionChecker struct {
+	host    *hostFacts
+	results map[string]bool
+}
+
+//Check returns whether the condition in the given .holocondition file (if it
+//exists) is satisfied by the current host.
+func (cc *conditionChecker) Check(conditionPath string) (bool, error) {
+	if result, ok := cc.results[conditionPath]; ok {
+		return result, nil
+	}
+	result := true
+	if common.IsManageableFile(conditionPath) {
+		//host facts are only collected when they are needed
+		if cc.host == nil {
+			host, err := collectHostFacts()
+			if err != nil {
+				return false, err
+			}
+			cc.host = host
+		}
+		condition, err := readCondition(conditionPath)
+		if err != nil {
+			return false, err
+		}
+		result = condition.Matches(cc.host)
+	}
+	cc.results[conditionPath] = result
+	return result, nil
+}
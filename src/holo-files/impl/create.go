@@ -0,0 +1,66 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"../common"
+)
+
+//IsCreated returns whether this target file was created from scratch by Holo
+//(because its .holometa file declares "create = true"), instead of being
+//installed by the package management. Such targets have an empty target base.
+func (target *TargetFile) IsCreated() bool {
+	return common.IsManageableFile(target.PathIn(common.CreatedDirectory()))
+}
+
+//createTargetBase records an empty target base for a target file that does
+//not exist yet, and marks the target as created by Holo, so that it will be
+//deleted instead of restored when its repository entries go away.
+func (target *TargetFile) createTargetBase() error {
+	paths := []string{
+		target.PathIn(common.TargetBaseDirectory()),
+		target.PathIn(common.CreatedDirectory()),
+	}
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("Cannot create directory %s: %s", dir, err.Error())
+		}
+		err = ioutil.WriteFile(path, nil, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	//the directory containing the target file might not exist either
+	targetDir := filepath.Dir(target.PathIn(common.TargetDirectory()))
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		return fmt.Errorf("Cannot create directory %s: %s", targetDir, err.Error())
+	}
+	return nil
+}
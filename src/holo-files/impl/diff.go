@@ -23,102 +23,189 @@ package impl
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
-	"regexp"
 	"strings"
-	"syscall"
 
+	"../../shared/textdiff"
 	"../common"
 )
 
-type fileType int
-
-const (
-	fileUnknown fileType = 0
-	fileMissing fileType = 1
-	fileRegular fileType = 2
-	fileSymlink fileType = 3
-)
-
 //RenderDiff creates a unified diff of a target file and its last provisioned
 //version, similar to `diff /var/lib/holo/files/provisioned/$FILE $FILE`, but it also
 //handles symlinks and missing files gracefully. The output is always a patch
 //that can be applied to last provisioned version into the current version.
+//The format of the diff follows git-diff(1), but can be changed by the user
+//(see textdiff.OptionsFromEnvironment()).
 func (target *TargetFile) RenderDiff() ([]byte, error) {
+	opts, err := textdiff.OptionsFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	//for directories, only the file metadata can be diffed
+	if target.directory {
+		return target.renderMetadataDiff(target.directoryMarkerPath(), target.PathIn(common.TargetDirectory()), opts)
+	}
+
 	fromPath := target.PathIn(common.ProvisionedDirectory())
 	toPath := target.PathIn(common.TargetDirectory())
 
-	fromPathToUse, err := checkFile(fromPath)
+	from, err := readDiffSide(fromPath)
 	if err != nil {
 		return nil, err
 	}
-	toPathToUse, err := checkFile(toPath)
+	to, err := readDiffSide(toPath)
 	if err != nil {
 		return nil, err
 	}
 
-	//run git-diff to obtain the diff
+	//the provisioned path is not shown, to make it appear like we just diff
+	//the target path
 	var buffer bytes.Buffer
-	cmd := exec.Command("git", "diff", "--no-index", "--", fromPathToUse, toPathToUse)
-	cmd.Stdout = &buffer
-	cmd.Stderr = os.Stderr
-
-	//error "exit code 1" is normal for different files, only exit code > 2 means trouble
-	err = cmd.Run()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				if status.ExitStatus() == 1 {
-					err = nil
-				}
-			}
-		}
+	displayPath := strings.TrimPrefix(toPath, "/")
+	if from.Exists && to.Exists && from.Mode != to.Mode && (from.Mode == symlinkMode || to.Mode == symlinkMode) {
+		//like git, show a change of the file type as deletion and recreation
+		renderFileDiff(&buffer, displayPath, from, diffSide{}, opts)
+		renderFileDiff(&buffer, displayPath, diffSide{}, to, opts)
+	} else {
+		renderFileDiff(&buffer, displayPath, from, to, opts)
 	}
-	//did a relevant error occur?
+
+	//the declared file metadata is diffed separately
+	metadataDiff, err := target.renderMetadataDiff(fromPath, toPath, opts)
 	if err != nil {
 		return nil, err
 	}
+	return append(buffer.Bytes(), metadataDiff...), nil
+}
 
-	//remove "index <SHA1>..<SHA1> <mode>" lines
-	result := buffer.Bytes()
-	rx := regexp.MustCompile(`(?m:^index .*$)\n`)
-	result = rx.ReplaceAll(result, nil)
+//file modes as displayed by git-diff(1)
+const (
+	regularMode    = "100644"
+	executableMode = "100755"
+	symlinkMode    = "120000"
+)
 
-	//remove "/var/lib/holo/files/provisioned" from path displays to make it appear like we
-	//just diff the target path
-	if fromPathToUse == fromPath {
-		fromPathQuoted := strings.TrimPrefix(regexp.QuoteMeta(fromPath), "/")
-		toPathQuoted := strings.TrimPrefix(regexp.QuoteMeta(toPath), "/")
-		toPathTrimmed := strings.TrimPrefix(toPath, "/")
+//diffSide describes one of the files that are compared by RenderDiff().
+type diffSide struct {
+	Exists bool
+	Mode   string
+	//for symlinks, this is the link target
+	Contents []byte
+}
+
+func readDiffSide(path string) (diffSide, error) {
+	//check that files are either non-existent or manageable (e.g. we can't
+	//diff directories or device files)
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return diffSide{}, nil
+		}
+		return diffSide{}, err
+	}
+	if !common.IsManageableFileInfo(info) {
+		return diffSide{}, fmt.Errorf("%s is not a manageable file", path)
+	}
 
-		rx = regexp.MustCompile(`(?m:^)diff --git a/` + fromPathQuoted)
-		result = rx.ReplaceAll(result, []byte("diff --git a/"+toPathTrimmed))
+	if common.IsFileInfoASymbolicLink(info) {
+		linkTarget, err := os.Readlink(path)
+		return diffSide{true, symlinkMode, []byte(linkTarget)}, err
+	}
+	contents, err := ioutil.ReadFile(path)
+	if info.Mode()&0111 != 0 {
+		return diffSide{true, executableMode, contents}, err
+	}
+	return diffSide{true, regularMode, contents}, err
+}
 
-		rx = regexp.MustCompile(`(?m:^)diff --git a/` + toPathQuoted + ` b/` + fromPathQuoted)
-		result = rx.ReplaceAll(result, []byte("diff --git a/"+toPathTrimmed+" b/"+toPathTrimmed))
+//renderFileDiff writes a diff between the given versions of a file in the
+//format of git-diff(1).
+func renderFileDiff(buffer *bytes.Buffer, displayPath string, from, to diffSide, opts textdiff.Options) {
+	if !from.Exists && !to.Exists {
+		return
+	}
+	if from.Exists && to.Exists && from.Mode == to.Mode && bytes.Equal(from.Contents, to.Contents) {
+		return
+	}
 
-		rx = regexp.MustCompile(`(?m:^)--- a/` + fromPathQuoted)
-		result = rx.ReplaceAll(result, []byte("--- a/"+toPathTrimmed))
+	fmt.Fprintf(buffer, "diff --git a/%s b/%s\n", displayPath, displayPath)
+	fromName, toName := "a/"+displayPath, "b/"+displayPath
+	switch {
+	case !from.Exists:
+		fmt.Fprintf(buffer, "new file mode %s\n", to.Mode)
+		fromName = "/dev/null"
+	case !to.Exists:
+		fmt.Fprintf(buffer, "deleted file mode %s\n", from.Mode)
+		toName = "/dev/null"
+	case from.Mode != to.Mode:
+		fmt.Fprintf(buffer, "old mode %s\nnew mode %s\n", from.Mode, to.Mode)
 	}
 
-	return result, nil
+	if bytes.Equal(from.Contents, to.Contents) {
+		return
+	}
+	if textdiff.IsBinary(from.Contents) || textdiff.IsBinary(to.Contents) {
+		fmt.Fprintf(buffer, "Binary files %s and %s differ\n", fromName, toName)
+		return
+	}
+	fmt.Fprintf(buffer, "--- %s\n+++ %s\n", fromName, toName)
+	buffer.Write(textdiff.Render(
+		textdiff.SplitLines(string(from.Contents)),
+		textdiff.SplitLines(string(to.Contents)),
+		opts,
+	))
 }
 
-func checkFile(path string) (pathToUse string, returnError error) {
-	//check that files are either non-existent (in which case git-diff needs to
-	//be given /dev/null instead or manageable (e.g. we can't diff directories
-	//or device files)
-	info, err := os.Lstat(path)
+//renderMetadataDiff creates a unified diff of the declared file metadata (as
+//described by Metadata.Describe()) of the given files. The result is empty if
+//there is no declared file metadata, if one of the files does not exist, or if
+//the declared file metadata is identical.
+func (target *TargetFile) renderMetadataDiff(fromPath, toPath string, opts textdiff.Options) ([]byte, error) {
+	metadata, err := target.Metadata()
+	if err != nil || metadata == nil {
+		return nil, err
+	}
+	if !fileExists(fromPath) || !fileExists(toPath) {
+		return nil, nil
+	}
+	fromLines, err := metadata.Describe(fromPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return "/dev/null", nil
-		}
-		return path, err
+		return nil, err
+	}
+	toLines, err := metadata.Describe(toPath)
+	if err != nil {
+		return nil, err
 	}
+	return renderMetadataLines(strings.TrimPrefix(toPath, "/"), fromLines, toLines, opts), nil
+}
 
-	if !common.IsManageableFileInfo(info) {
-		return path, fmt.Errorf("%s is not a manageable file", path)
+//renderMetadataLines creates a unified diff of the given descriptions of file
+//metadata (as returned by Metadata.Describe()), or nothing if they are
+//identical.
+func renderMetadataLines(displayPath string, fromLines, toLines []string, opts textdiff.Options) []byte {
+	if strings.Join(fromLines, "\n") == strings.Join(toLines, "\n") {
+		return nil
 	}
-	return path, nil
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "--- a/%s (metadata)\n+++ b/%s (metadata)\n", displayPath, displayPath)
+	//there are only a few lines, so show all of them
+	opts.Context = len(fromLines) + len(toLines)
+	buffer.Write(textdiff.Render(withNewlines(fromLines), withNewlines(toLines), opts))
+	return buffer.Bytes()
+}
+
+func withNewlines(lines []string) []string {
+	result := make([]string, len(lines))
+	for idx, line := range lines {
+		result[idx] = line + "\n"
+	}
+	return result
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
 }
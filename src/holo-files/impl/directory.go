@@ -0,0 +1,214 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"../common"
+)
+
+//directoryMarkerPath returns the path of the marker file that records that
+//this directory was provisioned by Holo. The file metadata of the marker file
+//is the file metadata of the directory at that time (for detecting changes
+//made by the user, like the provisioned copy of a target file). The ".holodir"
+//suffix ensures that the markers of nested directories do not collide.
+func (target *TargetFile) directoryMarkerPath() string {
+	return target.PathIn(common.DirectoryMarkerDirectory()) + ".holodir"
+}
+
+//printDirectoryReport is the part of PrintReport() for directories.
+func (target *TargetFile) printDirectoryReport() {
+	dirPath := target.PathIn(common.TargetDirectory())
+
+	if target.orphaned {
+		strategy, assessment := target.scanOrphanedDirectory()
+		fmt.Printf("ACTION: Scrubbing (%s)\n", assessment)
+		for _, entityID := range target.after {
+			fmt.Printf("AFTER: %s\n", entityID)
+		}
+		fmt.Printf("%s: %s\n", strategy, dirPath)
+		return
+	}
+
+	metadata, err := target.Metadata()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+	} else if metadata != nil {
+		for _, dep := range metadata.Dependencies() {
+			fmt.Printf("REQUIRES: %s\n", dep)
+		}
+	}
+	for _, file := range target.DirectoryFiles() {
+		fmt.Printf("%s: %s\n", file.ApplicationStrategy(), file.Path())
+	}
+}
+
+//applyDirectory is the equivalent of apply() for directories. It creates the
+//directory if necessary, and applies the declared file metadata to it.
+func applyDirectory(target *TargetFile, withForce bool) (skipReport bool, err error) {
+	if target.orphaned {
+		return false, target.handleOrphanedDirectory()
+	}
+
+	dirPath := target.PathIn(common.TargetDirectory())
+	markerPath := target.directoryMarkerPath()
+	exists, needsUpdate, err := target.checkDirectory(withForce)
+	if err != nil {
+		return false, err
+	}
+	if !needsUpdate {
+		//since we did not do anything, don't report this
+		return true, nil
+	}
+
+	metadata, err := target.Metadata()
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		err := os.MkdirAll(dirPath, 0755)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = metadata.Apply(dirPath)
+	if err != nil {
+		return false, err
+	}
+
+	//record the file metadata of the directory to check for manual
+	//modifications in the next applyDirectory() run
+	markerDir := filepath.Dir(markerPath)
+	err = os.MkdirAll(markerDir, 0755)
+	if err != nil {
+		return false, fmt.Errorf("Cannot create directory %s: %s", markerDir, err.Error())
+	}
+	err = ioutil.WriteFile(markerPath, nil, 0644)
+	if err != nil {
+		return false, err
+	}
+	return false, metadata.Apply(markerPath)
+}
+
+//planDirectory predicts what applyDirectory() would do.
+func planDirectory(target *TargetFile, withForce bool) (skipReport bool, err error) {
+	if target.orphaned {
+		strategy, _ := target.scanOrphanedDirectory()
+		if strategy == "delete" && fileExists(target.PathIn(common.TargetDirectory())) {
+			fmt.Printf("would delete %s\n", target.PathIn(common.TargetDirectory()))
+		}
+		return false, nil
+	}
+
+	exists, needsUpdate, err := target.checkDirectory(withForce)
+	if err != nil || !needsUpdate {
+		return !needsUpdate, err
+	}
+	if exists {
+		fmt.Printf("would update %s\n", target.PathIn(common.TargetDirectory()))
+	} else {
+		fmt.Printf("would create %s\n", target.PathIn(common.TargetDirectory()))
+	}
+	return false, nil
+}
+
+//checkDirectory contains the checks shared by applyDirectory() and
+//planDirectory(). It refuses to touch directories that were deleted or
+//changed by the user (unless withForce is given), and checks whether the
+//directory needs to be created or updated at all.
+func (target *TargetFile) checkDirectory(withForce bool) (exists, needsUpdate bool, err error) {
+	dirPath := target.PathIn(common.TargetDirectory())
+	markerPath := target.directoryMarkerPath()
+	metadata, err := target.Metadata()
+	if err != nil {
+		return false, false, err
+	}
+
+	info, err := os.Lstat(dirPath)
+	switch {
+	case err == nil:
+		if !info.IsDir() {
+			return true, false, errors.New("skipping target: not a directory")
+		}
+		exists = true
+	case os.IsNotExist(err):
+		exists = false
+	default:
+		return false, false, err
+	}
+
+	//complain if the user deleted the directory or changed its file metadata
+	//since the last applyDirectory() run
+	provisioned := fileExists(markerPath)
+	if !withForce && provisioned {
+		if !exists {
+			return false, false, needsForceError("skipping target: directory has been deleted by user (use --force to restore)")
+		}
+		drifted, err := metadataDrifted(metadata, dirPath, markerPath)
+		if err != nil {
+			return true, false, err
+		}
+		if drifted {
+			return true, false, needsForceError("skipping target: directory metadata has been modified by user (use --force to overwrite)")
+		}
+	}
+
+	if !exists || !provisioned || withForce {
+		return exists, true, nil
+	}
+	matches, err := metadata.Matches(dirPath)
+	return exists, !matches, err
+}
+
+//scanOrphanedDirectory assesses what to do with a directory whose .holodir
+//repo files were deleted. Only empty directories are deleted.
+func (target *TargetFile) scanOrphanedDirectory() (strategy, assessment string) {
+	dirPath := target.PathIn(common.TargetDirectory())
+	if !fileExists(dirPath) {
+		return "delete", "target was deleted"
+	}
+	entries, err := ioutil.ReadDir(dirPath)
+	if err != nil || len(entries) > 0 {
+		return "keep", "all repository files were deleted, but directory is not empty"
+	}
+	return "delete", "all repository files were deleted"
+}
+
+//handleOrphanedDirectory cleans up a directory whose .holodir repo files were
+//deleted.
+func (target *TargetFile) handleOrphanedDirectory() error {
+	dirPath := target.PathIn(common.TargetDirectory())
+	strategy, _ := target.scanOrphanedDirectory()
+	if strategy == "delete" && fileExists(dirPath) {
+		err := os.Remove(dirPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	//directory is not managed by Holo anymore, so forget about it
+	return os.Remove(target.directoryMarkerPath())
+}
@@ -0,0 +1,272 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+//The .holoedit application strategy edits key-value or INI-style files in
+//place. Each line of the repo file is one of:
+//
+//* a comment (starting with "#" or ";") or an empty line, which is ignored,
+//* a section header like "[Service]", which selects the section of the target
+//  file in which the following lines operate (lines before the first section
+//  header operate on the part of the target file before its first section
+//  header, or before its first "Match" or "Host" block, as found in
+//  sshd_config(5) and ssh_config(5)),
+//* a line like "Key=Value" or "Key Value", which replaces the first line in
+//  the section that sets the same key, and removes all further lines in the
+//  section that set this key (since many formats use the last setting); if the
+//  key is not set yet, the line is inserted after the first commented-out line
+//  setting that key (like "#Key Value"), or at the end of the section,
+//* a line like "-Key", which removes all lines in the section that set this
+//  key,
+//* the directive "%ignore-case", which makes all operations in this repo file
+//  compare keys case-insensitively (e.g. for sshd_config(5)).
+//
+//All other lines of the target file (including comments) are kept as they are.
+
+//editOperation is a single line of a .holoedit repo file.
+type editOperation struct {
+	section    string //"" for the part before the first section header
+	key        string
+	line       string //"" for deletions
+	ignoreCase bool
+}
+
+func applyEdit(repoFile RepoFile, buffer *FileBuffer) (*FileBuffer, error) {
+	//this application strategy requires file contents
+	buffer, err := buffer.ResolveSymlink()
+	if err != nil {
+		return nil, err
+	}
+
+	operations, err := readEditOperations(repoFile.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	contents := strings.TrimSuffix(string(buffer.Contents), "\n")
+	if contents != "" {
+		lines = strings.Split(contents, "\n")
+	}
+	for _, op := range operations {
+		lines = op.applyTo(lines)
+	}
+
+	result := ""
+	if len(lines) > 0 {
+		result = strings.Join(lines, "\n") + "\n"
+	}
+	return NewFileBufferFromContents([]byte(result), buffer.BasePath), nil
+}
+
+//readEditOperations parses a .holoedit repo file.
+func readEditOperations(path string) ([]editOperation, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []editOperation
+	section := ""
+	ignoreCase := false
+	for idx, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || isEditComment(line) {
+			continue
+		}
+		if name, ok := parseSectionHeader(line); ok {
+			section = name
+			continue
+		}
+		if strings.HasPrefix(line, "%") {
+			if line != "%ignore-case" {
+				return nil, fmt.Errorf("%s:%d: unknown directive \"%s\"", path, idx+1, line)
+			}
+			ignoreCase = true
+			continue
+		}
+
+		op := editOperation{section: section, line: line}
+		if strings.HasPrefix(line, "-") {
+			op.key = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+			op.line = ""
+		} else {
+			op.key = parseEditKey(line)
+		}
+		if op.key == "" {
+			return nil, fmt.Errorf("%s:%d: expected key", path, idx+1)
+		}
+		result = append(result, op)
+	}
+
+	//the %ignore-case directive applies to the whole repo file
+	for idx := range result {
+		result[idx].ignoreCase = ignoreCase
+	}
+	return result, nil
+}
+
+func isEditComment(line string) bool {
+	return strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";")
+}
+
+//parseSectionHeader recognizes lines like "[Service]".
+func parseSectionHeader(line string) (name string, ok bool) {
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		return strings.TrimSpace(line[1 : len(line)-1]), true
+	}
+	return "", false
+}
+
+//parseEditKey returns the key of a line like "Key=Value", "Key = Value" or
+//"Key Value".
+func parseEditKey(line string) string {
+	line = strings.TrimSpace(line)
+	end := strings.IndexAny(line, "= \t")
+	if end == -1 {
+		return line
+	}
+	return strings.TrimSpace(line[:end])
+}
+
+//matchesKey checks if the given line (which is not a comment) sets the key of
+//this operation.
+func (op editOperation) matchesKey(line string) bool {
+	key := parseEditKey(line)
+	if op.ignoreCase {
+		return strings.EqualFold(key, op.key)
+	}
+	return key == op.key
+}
+
+//applyTo applies this operation to the given lines of a target file.
+func (op editOperation) applyTo(lines []string) []string {
+	start, end, found := findSection(lines, op.section)
+	if !found {
+		if op.line == "" {
+			//nothing to delete
+			return lines
+		}
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		return append(lines, "["+op.section+"]", op.line)
+	}
+
+	//replace the first line setting this key and remove all further lines
+	//setting this key (or remove all of them, for deletions)
+	var result []string
+	result = append(result, lines[:start]...)
+	replaced := false
+	insertAt := start //after the last non-empty line of the section
+	settingAt := -1   //after the last non-comment line of the section
+	commentedAt := -1 //after the first commented-out line setting this key
+	for idx := start; idx < end; idx++ {
+		line := lines[idx]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			result = append(result, line)
+			continue
+		case isEditComment(trimmed):
+			result = append(result, line)
+			if commentedAt == -1 && op.matchesKey(strings.TrimLeft(trimmed, "#; \t")) {
+				commentedAt = len(result)
+			}
+		case op.matchesKey(trimmed) && op.line != "" && !replaced:
+			result = append(result, op.line)
+			replaced = true
+		case op.matchesKey(trimmed):
+			//delete this line
+		default:
+			result = append(result, line)
+		}
+		insertAt = len(result)
+		if !isEditComment(trimmed) {
+			settingAt = len(result)
+		}
+	}
+	result = append(result, lines[end:]...)
+
+	//comments directly before a "Match" or "Host" block usually describe that
+	//block, so insert before them
+	if end < len(lines) && isConditionalBlock(strings.TrimSpace(lines[end])) && settingAt != -1 {
+		insertAt = settingAt
+	}
+
+	//insert into the section if the key was not set yet
+	if op.line != "" && !replaced {
+		if commentedAt != -1 {
+			insertAt = commentedAt
+		}
+		result = append(result[:insertAt], append([]string{op.line}, result[insertAt:]...)...)
+	}
+	return result
+}
+
+//findSection returns the range of lines belonging to the given section
+//(excluding the section header).
+func findSection(lines []string, section string) (start, end int, found bool) {
+	start = -1
+	if section == "" {
+		start = 0
+	}
+	for idx, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		//"Match" and "Host" blocks extend to the end of the file (or to the
+		//next such block), so they can only end the part before the first
+		//section header
+		if section == "" && start != -1 && isConditionalBlock(trimmed) {
+			return start, idx, true
+		}
+		name, ok := parseSectionHeader(trimmed)
+		if !ok {
+			continue
+		}
+		if start != -1 {
+			return start, idx, true
+		}
+		if name == section {
+			start = idx + 1
+		}
+	}
+	if start == -1 {
+		return 0, 0, false
+	}
+	return start, len(lines), true
+}
+
+//isConditionalBlock recognizes lines that start a "Match" block in
+//sshd_config(5) or a "Host" block in ssh_config(5). Settings in these blocks
+//only apply conditionally, so they are not part of the global section.
+func isConditionalBlock(line string) bool {
+	if isEditComment(line) {
+		return false
+	}
+	key := parseEditKey(line)
+	return strings.EqualFold(key, "Match") || strings.EqualFold(key, "Host")
+}
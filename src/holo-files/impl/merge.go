@@ -0,0 +1,187 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"../../shared/textdiff"
+	"../common"
+)
+
+//mergeLines performs a three-way merge of the given lists of lines (as
+//returned by textdiff.SplitLines), similar to diff3(1): Changes from base to
+//ours and from base to theirs are combined. If both sides changed the same region of
+//base in different ways, the region is a conflict. In this case, the result
+//contains both versions of the region, delimited by conflict markers with the
+//given labels, and the conflict count is positive.
+func mergeLines(base, ours, theirs []string, oursLabel, baseLabel, theirsLabel string) (result []string, conflicts int) {
+	matchOurs := textdiff.MatchLines(base, ours)
+	matchTheirs := textdiff.MatchLines(base, theirs)
+
+	o, a, b := 0, 0, 0
+	for o < len(base) || a < len(ours) || b < len(theirs) {
+		//stable region: lines that are unchanged on both sides
+		stable := 0
+		for o+stable < len(base) && matchOurs[o+stable] == a+stable && matchTheirs[o+stable] == b+stable {
+			stable++
+		}
+		if stable > 0 {
+			result = append(result, base[o:o+stable]...)
+			o, a, b = o+stable, a+stable, b+stable
+			continue
+		}
+
+		//unstable region: extends until the next line of base that is
+		//unchanged on both sides (or until the end)
+		nextO, nextA, nextB := len(base), len(ours), len(theirs)
+		for idx := o; idx < len(base); idx++ {
+			if matchOurs[idx] != -1 && matchTheirs[idx] != -1 {
+				nextO, nextA, nextB = idx, matchOurs[idx], matchTheirs[idx]
+				break
+			}
+		}
+		baseChunk, oursChunk, theirsChunk := base[o:nextO], ours[a:nextA], theirs[b:nextB]
+
+		switch {
+		case linesEqual(oursChunk, baseChunk):
+			result = append(result, theirsChunk...)
+		case linesEqual(theirsChunk, baseChunk), linesEqual(oursChunk, theirsChunk):
+			result = append(result, oursChunk...)
+		default:
+			conflicts++
+			result = append(result, "<<<<<<< "+oursLabel+"\n")
+			result = appendWithNewline(result, oursChunk)
+			result = append(result, "||||||| "+baseLabel+"\n")
+			result = appendWithNewline(result, baseChunk)
+			result = append(result, "=======\n")
+			result = appendWithNewline(result, theirsChunk)
+			result = append(result, ">>>>>>> "+theirsLabel+"\n")
+		}
+		o, a, b = nextO, nextA, nextB
+	}
+	return result, conflicts
+}
+
+func linesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+//appendWithNewline appends the given lines, and makes sure that the last one
+//ends with a newline (so that a conflict marker can follow).
+func appendWithNewline(result, lines []string) []string {
+	result = append(result, lines...)
+	if len(result) > 0 && !strings.HasSuffix(result[len(result)-1], "\n") {
+		result[len(result)-1] += "\n"
+	}
+	return result
+}
+
+//mergeResult describes the outcome of TargetFile.prepareMerge().
+type mergeResult struct {
+	//the merged contents for the target file (with conflict markers if
+	//Conflicts > 0)
+	Contents  []byte
+	Conflicts int
+	//what would be provisioned from the updated target base if there were no
+	//changes made by the user
+	ProvisionedBuffer *FileBuffer
+}
+
+//prepareMerge merges the changes from the last provisioned version to the
+//new provisioned version (rendered from the updated target base at the given
+//path) into the current target file, without changing anything in the file
+//system. If there is nothing to merge (because the target file was not
+//changed by the user, or because one of the files is a symlink), nil is
+//returned.
+func (target *TargetFile) prepareMerge(updatedTBPath string) (*mergeResult, error) {
+	targetPath := target.PathIn(common.TargetDirectory())
+	lastProvisionedPath := target.PathIn(common.ProvisionedDirectory())
+
+	if !common.IsManageableFile(lastProvisionedPath) || !common.IsManageableFile(targetPath) {
+		return nil, nil
+	}
+	targetBuffer, err := NewFileBuffer(targetPath, targetPath)
+	if err != nil {
+		return nil, err
+	}
+	lastProvisionedBuffer, err := NewFileBuffer(lastProvisionedPath, targetPath)
+	if err != nil {
+		return nil, err
+	}
+	if targetBuffer.EqualTo(lastProvisionedBuffer) {
+		return nil, nil
+	}
+	newProvisionedBuffer, err := target.render(updatedTBPath)
+	if err != nil {
+		return nil, err
+	}
+	//(symlinks cannot be merged)
+	if targetBuffer.Contents == nil || lastProvisionedBuffer.Contents == nil || newProvisionedBuffer.Contents == nil {
+		return nil, nil
+	}
+
+	mergedLines, conflicts := mergeLines(
+		textdiff.SplitLines(string(lastProvisionedBuffer.Contents)),
+		textdiff.SplitLines(string(targetBuffer.Contents)),
+		textdiff.SplitLines(string(newProvisionedBuffer.Contents)),
+		"current target", "last provisioned version", "updated target base",
+	)
+	return &mergeResult{
+		Contents:          []byte(strings.Join(mergedLines, "")),
+		Conflicts:         conflicts,
+		ProvisionedBuffer: newProvisionedBuffer,
+	}, nil
+}
+
+//recordMergeResult is called by apply() after the changes made by the user
+//have been merged with an updated target base (see prepareMerge()). If the
+//merge failed because of conflicts, the merge result (with conflict markers)
+//is written to "$target.holomerge" for review. Otherwise, a stale
+//"$target.holomerge" from a previous run is removed.
+func (target *TargetFile) recordMergeResult(result *mergeResult) error {
+	conflictPath := target.PathIn(common.TargetDirectory()) + ".holomerge"
+	if result.Conflicts > 0 {
+		return ioutil.WriteFile(conflictPath, result.Contents, 0600)
+	}
+	err := os.Remove(conflictPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//mergeConflictError is not a needsForceError since --force would overwrite the
+//changes made by the user. The user has to resolve the conflict instead.
+func mergeConflictError(conflictPath string) error {
+	return fmt.Errorf("skipping target: cannot merge changes made by user with updated target base, see %s (resolve the conflict in the target file, then apply again)", conflictPath)
+}
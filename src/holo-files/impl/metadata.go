@@ -0,0 +1,340 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"../../internal/toml"
+	"../common"
+)
+
+//Metadata describes the file mode and ownership that is declared for a target
+//file in its .holometa repo files. These are TOML files with the optional
+//keys "mode" (an octal string like "0600"), "owner" and "group" (names or
+//numeric IDs). Fields that are empty are not managed by Holo; for them, the
+//target file inherits the file metadata of its target base.
+//
+//Furthermore, the key "create" can be set to true to allow Holo to create the
+//target file from scratch if it does not exist (see TargetFile.IsCreated()),
+//and the key "merge" can be set to true to keep changes made by the user when
+//an updated target base is found (see TargetFile.mergeUpdatedTargetBase()).
+type Metadata struct {
+	Mode   string `toml:"mode"`
+	Owner  string `toml:"owner"`
+	Group  string `toml:"group"`
+	Create bool   `toml:"create"`
+	Merge  bool   `toml:"merge"`
+}
+
+//Metadata returns the file metadata declared for this target file, or nil if
+//there are no .holometa repo files. If multiple .holometa files exist, the
+//fields declared in later files override those in earlier files.
+//
+//For directories, the file metadata is declared in the .holodir repo files.
+func (target *TargetFile) Metadata() (*Metadata, error) {
+	files := target.MetadataFiles()
+	if target.directory {
+		files = target.DirectoryFiles()
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	var result Metadata
+	for _, file := range files {
+		var m Metadata
+		md, err := toml.DecodeFile(file.Path(), &m)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %s", file.Path(), err.Error())
+		}
+		//a misspelled key would silently leave the file metadata unmanaged
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("cannot read %s: unknown key \"%s\"", file.Path(), undecoded[0].String())
+		}
+		if m.Mode != "" {
+			if _, err := parseMode(m.Mode); err != nil {
+				return nil, fmt.Errorf("cannot read %s: %s", file.Path(), err.Error())
+			}
+			result.Mode = m.Mode
+		}
+		if m.Owner != "" {
+			result.Owner = m.Owner
+		}
+		if m.Group != "" {
+			result.Group = m.Group
+		}
+		if m.Create {
+			result.Create = true
+		}
+		if m.Merge {
+			result.Merge = true
+		}
+	}
+	return &result, nil
+}
+
+//parseMode parses an octal file mode like "0600" or "2755".
+func parseMode(value string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || bits > 07777 {
+		return 0, fmt.Errorf("invalid file mode \"%s\"", value)
+	}
+
+	//os.FileMode does not use the UNIX bits for setuid/setgid/sticky
+	mode := os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+//formatMode is the inverse of parseMode.
+func formatMode(mode os.FileMode) string {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return fmt.Sprintf("%04o", bits)
+}
+
+//Apply sets the declared file metadata on the file at the given path.
+func (m *Metadata) Apply(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if m.Mode != "" {
+		if common.IsFileInfoASymbolicLink(info) {
+			return fmt.Errorf("cannot set file mode on %s: is a symlink", path)
+		}
+		mode, err := parseMode(m.Mode)
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(path, mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	//-1 means: don't change
+	uid, gid := -1, -1
+	if m.Owner != "" {
+		uid, err = lookupID("etc/passwd", "user", m.Owner)
+		if err != nil {
+			return err
+		}
+	}
+	if m.Group != "" {
+		gid, err = lookupID("etc/group", "group", m.Group)
+		if err != nil {
+			return err
+		}
+	}
+	if uid != -1 || gid != -1 {
+		return lchown(path, uid, gid)
+	}
+	return nil
+}
+
+//lchown is like os.Lchown, but in a test environment (see common.IsMock()), it
+//only prints the change instead of executing it.
+func lchown(path string, uid, gid int) error {
+	if common.IsMock() {
+		owner, group := "", ""
+		if uid != -1 {
+			owner = strconv.Itoa(uid)
+		}
+		if gid != -1 {
+			group = strconv.Itoa(gid)
+		}
+		fmt.Printf("MOCK: chown -h %s:%s %s\n", owner, group, path)
+		return nil
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+//Describe returns the declared file metadata in a human-readable form, with
+//one line for each declared field, e.g. "mode: 0600". If a path is given, the
+//actual metadata of the file at that path is described instead (but still
+//only for the fields declared in this Metadata instance).
+func (m *Metadata) Describe(path string) ([]string, error) {
+	var lines []string
+	if path == "" {
+		if m.Mode != "" {
+			mode, _ := parseMode(m.Mode) //was validated in TargetFile.Metadata()
+			lines = append(lines, "mode: "+formatMode(mode))
+		}
+		if m.Owner != "" {
+			lines = append(lines, "owner: "+m.Owner)
+		}
+		if m.Group != "" {
+			lines = append(lines, "group: "+m.Group)
+		}
+		return lines, nil
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if m.Mode != "" {
+		lines = append(lines, "mode: "+formatMode(info.Mode()))
+	}
+	//in a test environment, the ownership is not actually changed (see
+	//lchown()), so pretend that it has the declared values
+	owner, group := m.Owner, m.Group
+	if !common.IsMock() {
+		owner = lookupName("etc/passwd", m.Owner, int(stat.Uid))
+		group = lookupName("etc/group", m.Group, int(stat.Gid))
+	}
+	if m.Owner != "" {
+		lines = append(lines, "owner: "+owner)
+	}
+	if m.Group != "" {
+		lines = append(lines, "group: "+group)
+	}
+	return lines, nil
+}
+
+//Matches checks whether the file at the given path has the declared file
+//metadata.
+func (m *Metadata) Matches(path string) (bool, error) {
+	expected, _ := m.Describe("")
+	actual, err := m.Describe(path)
+	if err != nil {
+		return false, err
+	}
+	return strings.Join(expected, "\n") == strings.Join(actual, "\n"), nil
+}
+
+//Dependencies returns the entity IDs of the users and groups that are
+//referenced by name in this Metadata instance.
+func (m *Metadata) Dependencies() []string {
+	var result []string
+	if m.Owner != "" && !isNumeric(m.Owner) {
+		result = append(result, "user:"+m.Owner)
+	}
+	if m.Group != "" && !isNumeric(m.Group) {
+		result = append(result, "group:"+m.Group)
+	}
+	return result
+}
+
+func isNumeric(value string) bool {
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
+
+//lookupID resolves a user or group name into its numeric ID using the given
+//database file (e.g. "etc/passwd") below the target directory. Numeric names
+//are accepted as they are.
+func lookupID(databaseFile, kind, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	fields, err := getent(databaseFile, func(fields []string) bool {
+		return fields[0] == name
+	})
+	if err != nil {
+		return -1, err
+	}
+	if len(fields) < 3 {
+		return -1, fmt.Errorf("unknown %s \"%s\"", kind, name)
+	}
+	id, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return -1, fmt.Errorf("invalid entry for %s \"%s\" in %s", kind, name, databaseFile)
+	}
+	return id, nil
+}
+
+//lookupName is the inverse of lookupID. To make the result comparable with the
+//declared name, a numeric ID is returned if the declared name is numeric, or
+//if the ID cannot be resolved.
+func lookupName(databaseFile, declaredName string, id int) string {
+	idStr := strconv.Itoa(id)
+	if isNumeric(declaredName) {
+		return idStr
+	}
+	fields, err := getent(databaseFile, func(fields []string) bool {
+		return len(fields) > 2 && fields[2] == idStr
+	})
+	if err != nil || len(fields) == 0 {
+		return idStr
+	}
+	return fields[0]
+}
+
+//getent reads entries from a UNIX user/group database below the target
+//directory and returns the first entry matching the given predicate.
+func getent(databaseFile string, predicate func([]string) bool) ([]string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(common.TargetDirectory(), databaseFile))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if predicate(fields) {
+			return fields, nil
+		}
+	}
+	return nil, nil
+}
+
+//metadataDrifted checks whether the declared fields of the file metadata
+//differ between the target file and its last provisioned version (i.e.
+//whether the user has changed the file metadata since the last apply).
+func metadataDrifted(metadata *Metadata, targetPath, provisionedPath string) (bool, error) {
+	if metadata == nil {
+		return false, nil
+	}
+	targetLines, err := metadata.Describe(targetPath)
+	if err != nil {
+		return false, err
+	}
+	provisionedLines, err := metadata.Describe(provisionedPath)
+	if err != nil {
+		return false, err
+	}
+	return strings.Join(targetLines, "\n") != strings.Join(provisionedLines, "\n"), nil
+}
@@ -34,6 +34,10 @@ import (
 func (target *TargetFile) scanOrphanedTargetBase() (theTargetPath, strategy, assessment string) {
 	targetPath := target.PathIn(common.TargetDirectory())
 	if common.IsManageableFile(targetPath) {
+		//targets created by Holo have nothing to restore
+		if target.IsCreated() {
+			return targetPath, "delete", "all repository files were deleted"
+		}
 		return targetPath, "restore", "all repository files were deleted"
 	}
 	return targetPath, "delete", "target was deleted"
@@ -46,6 +50,13 @@ func (target *TargetFile) handleOrphanedTargetBase() error {
 
 	switch strategy {
 	case "delete":
+		//targets created by Holo are deleted along with their target base
+		if target.IsCreated() && common.IsManageableFile(targetPath) {
+			err := os.Remove(targetPath)
+			if err != nil {
+				return err
+			}
+		}
 		//if the package management left behind additional cleanup targets
 		//(most likely a backup of our custom configuration), we can delete
 		//these too
@@ -75,6 +86,14 @@ func (target *TargetFile) handleOrphanedTargetBase() error {
 	if err != nil {
 		return err
 	}
+	err = os.RemoveAll(target.PathIn(common.VersionsDirectory()))
+	if err != nil {
+		return err
+	}
+	err = os.Remove(target.PathIn(common.CreatedDirectory()))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 
 	//TODO: cleanup empty directories below TargetBaseDirectory() and ProvisionedDirectory()
 	return nil
@@ -0,0 +1,272 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//maxFuzz is the maximum fuzz factor for .holopatch repo files (the same
+//default as in patch(1)).
+const maxFuzz = 2
+
+//patchHunk is a single hunk from a unified diff.
+type patchHunk struct {
+	number   int    //1-based index of this hunk in the patch
+	header   string //the "@@ -a,b +c,d @@" line
+	oldStart int    //1-based line number
+	oldLines []string
+	newLines []string
+	//whether the last line of the old/new side has no trailing newline
+	oldNoEOL bool
+	newNoEOL bool
+	//number of context lines at the start and end of the hunk
+	leadingContext  int
+	trailingContext int
+}
+
+var hunkHeaderRx = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+//parsePatch reads the hunks from a unified diff. File headers and other
+//lines outside of hunks are ignored.
+func parsePatch(path string) ([]patchHunk, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+
+	var hunks []patchHunk
+	for idx := 0; idx < len(lines); idx++ {
+		match := hunkHeaderRx.FindStringSubmatch(lines[idx])
+		if match == nil {
+			continue
+		}
+		hunk := patchHunk{number: len(hunks) + 1, header: lines[idx]}
+		hunk.oldStart, _ = strconv.Atoi(match[1])
+		oldCount, newCount := 1, 1
+		if match[2] != "" {
+			oldCount, _ = strconv.Atoi(match[2])
+		}
+		if match[4] != "" {
+			newCount, _ = strconv.Atoi(match[4])
+		}
+
+		//read hunk lines until both sides are complete
+		seenChange := false
+		lastSide := ' '
+		for len(hunk.oldLines) < oldCount || len(hunk.newLines) < newCount || (idx+1 < len(lines) && strings.HasPrefix(lines[idx+1], "\\")) {
+			idx++
+			if idx >= len(lines) {
+				return nil, fmt.Errorf("%s: hunk #%d (%s) is truncated", path, hunk.number, hunk.header)
+			}
+			line := lines[idx]
+			if line == "" {
+				//some editors strip the trailing space of empty context lines
+				line = " "
+			}
+			switch line[0] {
+			case ' ':
+				hunk.oldLines = append(hunk.oldLines, line[1:])
+				hunk.newLines = append(hunk.newLines, line[1:])
+				if seenChange {
+					hunk.trailingContext++
+				} else {
+					hunk.leadingContext++
+				}
+			case '-':
+				hunk.oldLines = append(hunk.oldLines, line[1:])
+				seenChange = true
+				hunk.trailingContext = 0
+			case '+':
+				hunk.newLines = append(hunk.newLines, line[1:])
+				seenChange = true
+				hunk.trailingContext = 0
+			case '\\':
+				//"\ No newline at end of file" refers to the previous line
+				if lastSide != '+' {
+					hunk.oldNoEOL = true
+				}
+				if lastSide != '-' {
+					hunk.newNoEOL = true
+				}
+				continue
+			default:
+				return nil, fmt.Errorf("%s: hunk #%d (%s) contains unexpected line: %s", path, hunk.number, hunk.header, line)
+			}
+			lastSide = rune(line[0])
+		}
+		hunks = append(hunks, hunk)
+	}
+
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("%s: no hunks found", path)
+	}
+	return hunks, nil
+}
+
+func applyPatch(repoFile RepoFile, buffer *FileBuffer) (*FileBuffer, error) {
+	//this application strategy requires file contents
+	buffer, err := buffer.ResolveSymlink()
+	if err != nil {
+		return nil, err
+	}
+
+	hunks, err := parsePatch(repoFile.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	contents := string(buffer.Contents)
+	trailingNewline := contents == "" || strings.HasSuffix(contents, "\n")
+	var lines []string
+	if contents != "" {
+		lines = strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+	}
+
+	//like patch(1), the offset of a hunk is carried over to the following
+	//hunks, and hunks cannot apply before the end of the previous hunk
+	delta := 0
+	minPos := 0
+	for _, hunk := range hunks {
+		expectedPos := hunk.oldStart - 1 + delta
+		if len(hunk.oldLines) == 0 {
+			//for pure additions, oldStart is the line *after* which to insert
+			expectedPos++
+		}
+		pos, fuzz, err := hunk.locate(lines, expectedPos, minPos)
+		if err != nil {
+			return nil, fmt.Errorf("cannot apply hunk #%d of %s (%s): %s", hunk.number, repoFile.Path(), hunk.header, err.Error())
+		}
+
+		//replace the matched lines (without the context lines ignored by fuzz)
+		oldLines, newLines := hunk.withFuzz(fuzz)
+		var result []string
+		result = append(result, lines[:pos]...)
+		result = append(result, newLines...)
+		result = append(result, lines[pos+len(oldLines):]...)
+		if pos+len(oldLines) == len(lines) && (hunk.oldNoEOL || hunk.newNoEOL) {
+			trailingNewline = !hunk.newNoEOL
+		}
+		lines = result
+
+		minPos = pos + len(newLines)
+		leading, _, _, _ := hunk.fuzzedContext(fuzz)
+		hunkPos := pos - leading
+		delta = hunkPos - (hunk.oldStart - 1) + len(newLines) - len(oldLines)
+		if len(hunk.oldLines) == 0 {
+			delta--
+		}
+	}
+
+	result := strings.Join(lines, "\n")
+	if len(lines) > 0 && trailingNewline {
+		result += "\n"
+	}
+	return NewFileBufferFromContents([]byte(result), buffer.BasePath), nil
+}
+
+//fuzzedContext returns how many context lines at the start and end of this
+//hunk are ignored at the given fuzz factor. Like in patch(1), if the hunk has
+//less context at its start (or end) than at the other end, it was taken from
+//the start (or end) of the file, so it is anchored there unless enough fuzz is
+//given.
+func (hunk patchHunk) fuzzedContext(fuzz int) (leading, trailing int, anchoredAtStart, anchoredAtEnd bool) {
+	context := hunk.leadingContext
+	if hunk.trailingContext > context {
+		context = hunk.trailingContext
+	}
+
+	leading = fuzz + hunk.leadingContext - context
+	if leading < 0 {
+		leading, anchoredAtStart = 0, true
+	}
+	if leading > hunk.leadingContext {
+		leading = hunk.leadingContext
+	}
+	trailing = fuzz + hunk.trailingContext - context
+	if trailing < 0 {
+		trailing, anchoredAtEnd = 0, true
+	}
+	if trailing > hunk.trailingContext {
+		trailing = hunk.trailingContext
+	}
+	return
+}
+
+//withFuzz returns the old and new lines of this hunk, without the context
+//lines that are ignored at the given fuzz factor.
+func (hunk patchHunk) withFuzz(fuzz int) (oldLines, newLines []string) {
+	leading, trailing, _, _ := hunk.fuzzedContext(fuzz)
+	return hunk.oldLines[leading : len(hunk.oldLines)-trailing],
+		hunk.newLines[leading : len(hunk.newLines)-trailing]
+}
+
+//locate finds the position where this hunk applies, starting at the expected
+//position and moving outwards (like patch(1), the match with the smallest
+//fuzz factor wins, then the one with the smallest offset). The position
+//returned is the position for the lines returned by withFuzz(fuzz).
+func (hunk patchHunk) locate(lines []string, expectedPos, minPos int) (pos, fuzz int, err error) {
+	for fuzz = 0; fuzz <= maxFuzz; fuzz++ {
+		leading, _, anchoredAtStart, anchoredAtEnd := hunk.fuzzedContext(fuzz)
+		oldLines, _ := hunk.withFuzz(fuzz)
+
+		//the expected position is for the complete hunk, but we're matching
+		//without the leading context lines ignored by fuzz
+		start := expectedPos + leading
+		for offset := 0; offset <= len(lines); offset++ {
+			candidates := []int{start + offset, start - offset}
+			if offset == 0 {
+				candidates = candidates[:1]
+			}
+			for _, candidate := range candidates {
+				if candidate < minPos || candidate+len(oldLines) > len(lines) {
+					continue
+				}
+				//(when anchored, no context lines are ignored at that end)
+				if anchoredAtStart && candidate != 0 {
+					continue
+				}
+				if anchoredAtEnd && candidate+len(oldLines) != len(lines) {
+					continue
+				}
+				if linesMatch(lines[candidate:candidate+len(oldLines)], oldLines) {
+					return candidate, fuzz, nil
+				}
+			}
+		}
+	}
+	return 0, 0, errors.New("no matching lines found")
+}
+
+func linesMatch(actual, expected []string) bool {
+	for idx, line := range expected {
+		if actual[idx] != line {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"bytes"
+	"os"
+	"strings"
+
+	"../../shared/textdiff"
+	"../common"
+	"../platform"
+)
+
+//RenderPendingDiff creates a unified diff of a target file and the version
+//that the next `holo apply --force` would provision. Like plan(), this runs
+//the application algorithm in memory without changing anything in the file
+//system. The format of the diff is the same as for RenderDiff().
+func (target *TargetFile) RenderPendingDiff() ([]byte, error) {
+	opts, err := textdiff.OptionsFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := target.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	targetPath := target.PathIn(common.TargetDirectory())
+	displayPath := strings.TrimPrefix(targetPath, "/")
+
+	//for directories, only the file metadata can be diffed
+	if target.directory {
+		if metadata == nil || target.orphaned {
+			return nil, nil
+		}
+		var fromLines []string
+		if fileExists(targetPath) {
+			fromLines, err = metadata.Describe(targetPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		toLines, _ := metadata.Describe("")
+		return renderMetadataLines(displayPath, fromLines, toLines, opts), nil
+	}
+
+	from, err := readDiffSide(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	to, err := target.pendingDiffSide(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	if from.Exists && to.Exists && from.Mode != to.Mode && (from.Mode == symlinkMode || to.Mode == symlinkMode) {
+		//like git, show a change of the file type as deletion and recreation
+		renderFileDiff(&buffer, displayPath, from, diffSide{}, opts)
+		renderFileDiff(&buffer, displayPath, diffSide{}, to, opts)
+	} else {
+		renderFileDiff(&buffer, displayPath, from, to, opts)
+	}
+
+	//compare the file metadata of the target with the declared file metadata
+	if metadata != nil && to.Exists {
+		var fromLines []string
+		if from.Exists {
+			fromLines, err = metadata.Describe(targetPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		toLines, _ := metadata.Describe("")
+		buffer.Write(renderMetadataLines(displayPath, fromLines, toLines, opts))
+	}
+	return buffer.Bytes(), nil
+}
+
+//pendingDiffSide predicts what the next `holo apply --force` would write to
+//the target path.
+func (target *TargetFile) pendingDiffSide(metadata *Metadata) (diffSide, error) {
+	//orphaned target bases are either restored or deleted
+	if target.orphaned {
+		_, strategy, _ := target.scanOrphanedTargetBase()
+		if strategy == "restore" {
+			return readDiffSide(target.PathIn(common.TargetBaseDirectory()))
+		}
+		return diffSide{}, nil
+	}
+
+	//run the application algorithm in memory
+	p, err := target.prepareApply(true, func(targetPath string) (string, string, error) {
+		updatedTBPath, currentTargetPath, _ := platform.Implementation().ProbeUpdatedTargetBase(targetPath)
+		return updatedTBPath, currentTargetPath, nil
+	})
+	if err != nil {
+		return diffSide{}, err
+	}
+	if p.Buffer.Contents == nil {
+		return diffSide{true, symlinkMode, []byte(p.Buffer.SymlinkTarget)}, nil
+	}
+	return diffSide{true, pendingMode(p.BasePath, p.Metadata), p.Buffer.Contents}, nil
+}
+
+//pendingMode predicts the file mode (as displayed by git-diff(1)) of a
+//regular file provisioned by apply(): The mode is copied from the target base,
+//unless it is declared in the file metadata.
+func pendingMode(basePath string, metadata *Metadata) string {
+	var mode os.FileMode = 0644
+	if basePath != "" {
+		//(like common.ApplyFilePermissions(), this does not follow symlinks)
+		info, err := os.Lstat(basePath)
+		if err == nil {
+			mode = info.Mode().Perm()
+		}
+	}
+	if metadata != nil && metadata.Mode != "" {
+		mode, _ = parseMode(metadata.Mode) //was validated in TargetFile.Metadata()
+	}
+	if mode&0111 != 0 {
+		return executableMode
+	}
+	return regularMode
+}
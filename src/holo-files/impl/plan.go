@@ -0,0 +1,78 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"fmt"
+
+	"../common"
+	"../platform"
+)
+
+//plan predicts what apply() would do for the given TargetFile, without
+//changing anything in the file system.
+func plan(target *TargetFile, withForce bool) (skipReport bool, err error) {
+	targetPath := target.PathIn(common.TargetDirectory())
+	targetBasePath := target.PathIn(common.TargetBaseDirectory())
+
+	//run the application algorithm in memory (the package manager may have
+	//moved the current target out of the way, but we must not move it back)
+	p, err := target.prepareApply(withForce, func(targetPath string) (string, string, error) {
+		updatedTBPath, currentTargetPath, reportedTBPath := platform.Implementation().ProbeUpdatedTargetBase(targetPath)
+		if updatedTBPath != "" {
+			fmt.Printf(">> found updated target base: %s -> %s\n", reportedTBPath, targetBasePath)
+		}
+		return updatedTBPath, currentTargetPath, nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case p.Unchanged:
+		return true, nil
+	case p.IsMerged():
+		fmt.Printf("would merge changes made by user into %s\n", targetPath)
+	case p.Creating:
+		fmt.Printf("would create %s\n", targetPath)
+	default:
+		fmt.Printf("would write %s\n", targetPath)
+	}
+	return false, nil
+}
+
+//planOrphanedTargetBase predicts what handleOrphanedTargetBase would do.
+func (target *TargetFile) planOrphanedTargetBase() {
+	targetPath, strategy, _ := target.scanOrphanedTargetBase()
+
+	switch strategy {
+	case "delete":
+		if target.IsCreated() && common.IsManageableFile(targetPath) {
+			fmt.Printf("would delete %s\n", targetPath)
+		}
+		for _, otherFile := range platform.Implementation().AdditionalCleanupTargets(targetPath) {
+			fmt.Printf("would also delete %s\n", otherFile)
+		}
+	case "restore":
+		fmt.Printf("would restore %s from %s\n", targetPath, target.PathIn(common.TargetBaseDirectory()))
+	}
+	fmt.Printf("would delete %s\n", target.PathIn(common.TargetBaseDirectory()))
+}
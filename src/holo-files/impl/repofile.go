@@ -45,11 +45,31 @@ func (file RepoFile) Path() string {
 
 //TargetPath returns the path to the corresponding target file.
 func (file RepoFile) TargetPath() string {
-	//the optional ".holoscript" suffix appears only on repo files
+	//the optional ".holoscript", ".holotemplate", ".holoedit", ".holopatch",
+	//".holocheck", ".holometa" and ".holodir" suffixes appear only on repo
+	//files
 	repoFile := file.Path()
 	if strings.HasSuffix(repoFile, ".holoscript") {
 		repoFile = strings.TrimSuffix(repoFile, ".holoscript")
 	}
+	if strings.HasSuffix(repoFile, ".holotemplate") {
+		repoFile = strings.TrimSuffix(repoFile, ".holotemplate")
+	}
+	if strings.HasSuffix(repoFile, ".holoedit") {
+		repoFile = strings.TrimSuffix(repoFile, ".holoedit")
+	}
+	if strings.HasSuffix(repoFile, ".holopatch") {
+		repoFile = strings.TrimSuffix(repoFile, ".holopatch")
+	}
+	if strings.HasSuffix(repoFile, ".holocheck") {
+		repoFile = strings.TrimSuffix(repoFile, ".holocheck")
+	}
+	if strings.HasSuffix(repoFile, ".holometa") {
+		repoFile = strings.TrimSuffix(repoFile, ".holometa")
+	}
+	if strings.HasSuffix(repoFile, ".holodir") {
+		repoFile = strings.TrimSuffix(repoFile, ".holodir")
+	}
 
 	//make path relative
 	relPath, _ := filepath.Rel(common.ResourceDirectory(), repoFile)
@@ -63,15 +83,63 @@ func (file RepoFile) TargetPath() string {
 	return filepath.Join(common.TargetDirectory(), relPath)
 }
 
+//ConditionPath returns the path to the .holocondition file that can restrict
+//this repo file (and all other repo files for the same target in the same
+//disambiguation directory) to certain hosts. The file need not exist.
+func (file RepoFile) ConditionPath() string {
+	relPath, _ := filepath.Rel(common.ResourceDirectory(), file.Path())
+	disambiguator := strings.SplitN(relPath, fmt.Sprintf("%c", filepath.Separator), 2)[0]
+	relTargetPath, _ := filepath.Rel(common.TargetDirectory(), file.TargetPath())
+	return filepath.Join(common.ResourceDirectory(), disambiguator, relTargetPath) + ".holocondition"
+}
+
 //ApplicationStrategy returns the human-readable name for the strategy that
 //will be employed to apply this repo file.
 func (file RepoFile) ApplicationStrategy() string {
 	if strings.HasSuffix(file.Path(), ".holoscript") {
 		return "passthru"
 	}
+	if strings.HasSuffix(file.Path(), ".holotemplate") {
+		return "template"
+	}
+	if strings.HasSuffix(file.Path(), ".holoedit") {
+		return "edit"
+	}
+	if strings.HasSuffix(file.Path(), ".holopatch") {
+		return "patch"
+	}
+	if file.IsValidator() {
+		return "check"
+	}
+	if file.IsMetadata() {
+		return "metadata"
+	}
+	if file.IsDirectory() {
+		return "directory"
+	}
 	return "apply"
 }
 
+//IsValidator indicates whether this repo file is not an application step,
+//but a program that validates the result of the application algorithm before
+//it is written to the target path.
+func (file RepoFile) IsValidator() bool {
+	return strings.HasSuffix(file.Path(), ".holocheck")
+}
+
+//IsMetadata indicates whether this repo file is not an application step, but
+//declares the file mode and ownership of the target file (see Metadata).
+func (file RepoFile) IsMetadata() bool {
+	return strings.HasSuffix(file.Path(), ".holometa")
+}
+
+//IsDirectory indicates whether this repo file declares that the target is a
+//directory (instead of a file). It contains the file metadata for the
+//directory in the same format as a .holometa file.
+func (file RepoFile) IsDirectory() bool {
+	return strings.HasSuffix(file.Path(), ".holodir")
+}
+
 //DiscardsPreviousBuffer indicates whether applying this file will discard the
 //previous file buffer (and thus the effect of all previous application steps).
 //This is used as a hint by the application algorithm to decide whether
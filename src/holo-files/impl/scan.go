@@ -21,6 +21,7 @@
 package impl
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
@@ -29,16 +30,31 @@ import (
 	"../common"
 )
 
-//ScanRepo returns a slice of all the TargetFile entities.
+//ScanRepo returns a slice of all the TargetFile entities. If a fatal error
+//occurs, it is reported on stderr and nil is returned.
 func ScanRepo() []*TargetFile {
 	//walk over the repo to find repo files (and thus the corresponding target files)
 	targets := make(map[string]*TargetFile)
 	repoDir := common.ResourceDirectory()
+	conditions := conditionChecker{results: make(map[string]bool)}
+	var conditionErr error
 	filepath.Walk(repoDir, func(repoPath string, repoFileInfo os.FileInfo, err error) error {
 		//skip over unaccessible stuff
 		if err != nil {
 			return err
 		}
+		//skip disambiguation directories whose condition does not match this host
+		if repoFileInfo.IsDir() && filepath.Dir(repoPath) == repoDir {
+			matches, err := conditions.Check(repoPath + ".holocondition")
+			if err != nil {
+				conditionErr = err
+				return err
+			}
+			if !matches {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		//only look at manageable files (regular files or symlinks)
 		if !(repoFileInfo.Mode().IsRegular() || common.IsFileInfoASymbolicLink(repoFileInfo)) {
 			return nil
@@ -54,8 +70,22 @@ func ScanRepo() []*TargetFile {
 			return nil
 		}
 
-		//create new TargetFile if necessary and store the repo entry in it
+		//conditions are not repo entries themselves, and repo entries whose
+		//condition does not match this host are ignored
+		if isConditionFile(repoPath) {
+			return nil
+		}
 		repoEntry := NewRepoFile(repoPath)
+		matches, err := conditions.Check(repoEntry.ConditionPath())
+		if err != nil {
+			conditionErr = err
+			return err
+		}
+		if !matches {
+			return nil
+		}
+
+		//create new TargetFile if necessary and store the repo entry in it
 		targetPath := repoEntry.TargetPath()
 		if targets[targetPath] == nil {
 			targets[targetPath] = NewTargetFileFromPathIn(common.TargetDirectory(), targetPath)
@@ -63,6 +93,29 @@ func ScanRepo() []*TargetFile {
 		targets[targetPath].AddRepoEntry(repoEntry)
 		return nil
 	})
+	if conditionErr != nil {
+		fmt.Fprintf(os.Stderr, "!! %s\n", conditionErr.Error())
+		return nil
+	}
+
+	//validators and metadata files alone do not make a target file
+	var ambiguousPaths []string
+	for targetPath, target := range targets {
+		if target.directory && len(target.repoEntries) > 0 {
+			ambiguousPaths = append(ambiguousPaths, targetPath)
+		} else if len(target.repoEntries) == 0 && !target.directory {
+			delete(targets, targetPath)
+		}
+	}
+	//if it is unclear what a target is, it cannot be checked for orphaned
+	//target bases either, so give up
+	if len(ambiguousPaths) > 0 {
+		sort.Strings(ambiguousPaths)
+		for _, targetPath := range ambiguousPaths {
+			fmt.Fprintf(os.Stderr, "!! %s is declared as both a file and a directory\n", targetPath)
+		}
+		return nil
+	}
 
 	//walk over the target base directory to find orphaned target bases
 	targetBaseDir := common.TargetBaseDirectory()
@@ -92,6 +145,41 @@ func ScanRepo() []*TargetFile {
 		return nil
 	})
 
+	//walk over the directory markers to find orphaned directories
+	markerDir := common.DirectoryMarkerDirectory()
+	var orphanedDirs []*TargetFile
+	filepath.Walk(markerDir, func(markerPath string, markerFileInfo os.FileInfo, err error) error {
+		//skip over unaccessible stuff
+		if err != nil {
+			return err
+		}
+		if !markerFileInfo.Mode().IsRegular() || !strings.HasSuffix(markerPath, ".holodir") {
+			return nil
+		}
+
+		target := NewTargetFileFromPathIn(markerDir, strings.TrimSuffix(markerPath, ".holodir"))
+		targetPath := target.PathIn(common.TargetDirectory())
+		if targets[targetPath] == nil {
+			target.orphaned = true
+			target.directory = true
+			targets[targetPath] = target
+			orphanedDirs = append(orphanedDirs, target)
+		}
+		return nil
+	})
+
+	//orphaned directories can only be deleted after the orphaned directories
+	//below them
+	for _, dir := range orphanedDirs {
+		prefix := dir.relTargetPath + string(filepath.Separator)
+		for _, other := range orphanedDirs {
+			if strings.HasPrefix(other.relTargetPath, prefix) {
+				dir.after = append(dir.after, other.EntityID())
+			}
+		}
+		sort.Strings(dir.after)
+	}
+
 	//flatten result into list
 	result := make([]*TargetFile, 0, len(targets))
 	for _, target := range targets {
@@ -34,6 +34,12 @@ type TargetFile struct {
 	relTargetPath string //the target path relative to the common.TargetDirectory()
 	orphaned      bool   //default: false
 	repoEntries   RepoFiles
+	validators    RepoFiles
+	metadataFiles RepoFiles
+	//for directory entities
+	directory      bool
+	directoryFiles RepoFiles
+	after          []string
 }
 
 //NewTargetFileFromPathIn creates a TargetFile instance for which a path
@@ -58,8 +64,20 @@ func (target *TargetFile) PathIn(directory string) string {
 }
 
 //AddRepoEntry registers a new repository entry in this TargetFile instance.
+//Validators and metadata files are stored separately from the application
+//steps.
 func (target *TargetFile) AddRepoEntry(entry RepoFile) {
-	target.repoEntries = append(target.repoEntries, entry)
+	switch {
+	case entry.IsValidator():
+		target.validators = append(target.validators, entry)
+	case entry.IsMetadata():
+		target.metadataFiles = append(target.metadataFiles, entry)
+	case entry.IsDirectory():
+		target.directory = true
+		target.directoryFiles = append(target.directoryFiles, entry)
+	default:
+		target.repoEntries = append(target.repoEntries, entry)
+	}
 }
 
 //RepoEntries returns an ordered list of all repository entries for this
@@ -69,6 +87,32 @@ func (target *TargetFile) RepoEntries() RepoFiles {
 	return target.repoEntries
 }
 
+//Validators returns an ordered list of all validators for this TargetFile.
+func (target *TargetFile) Validators() RepoFiles {
+	sort.Sort(target.validators)
+	return target.validators
+}
+
+//MetadataFiles returns an ordered list of all .holometa repo files for this
+//TargetFile.
+func (target *TargetFile) MetadataFiles() RepoFiles {
+	sort.Sort(target.metadataFiles)
+	return target.metadataFiles
+}
+
+//DirectoryFiles returns an ordered list of all .holodir repo files for this
+//TargetFile.
+func (target *TargetFile) DirectoryFiles() RepoFiles {
+	sort.Sort(target.directoryFiles)
+	return target.directoryFiles
+}
+
+//IsDirectory returns whether this target is a directory (declared by .holodir
+//repo files) instead of a file.
+func (target *TargetFile) IsDirectory() bool {
+	return target.directory
+}
+
 //EntityID returns the entity ID for this target file.
 func (target *TargetFile) EntityID() string {
 	return target.PathIn(common.TargetDirectory())
@@ -79,30 +123,94 @@ func (target *TargetFile) EntityID() string {
 func (target *TargetFile) PrintReport() {
 	fmt.Printf("ENTITY: %s\n", target.EntityID())
 
-	if target.orphaned {
+	if target.directory {
+		target.printDirectoryReport()
+	} else if target.orphaned {
 		_, strategy, assessment := target.scanOrphanedTargetBase()
 		fmt.Printf("ACTION: Scrubbing (%s)\n", assessment)
 		fmt.Printf("%s: %s\n", strategy, target.PathIn(common.TargetBaseDirectory()))
 	} else {
 		fmt.Printf("store at: %s\n", target.PathIn(common.TargetBaseDirectory()))
-		for _, entry := range target.repoEntries {
+		//users and groups referenced by the file metadata need to exist first
+		metadata, err := target.Metadata()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+		} else if metadata != nil {
+			for _, dep := range metadata.Dependencies() {
+				fmt.Printf("REQUIRES: %s\n", dep)
+			}
+		}
+		for _, entry := range target.RepoEntries() {
 			fmt.Printf("%s: %s\n", entry.ApplicationStrategy(), entry.Path())
 		}
+		for _, validator := range target.Validators() {
+			fmt.Printf("%s: %s\n", validator.ApplicationStrategy(), validator.Path())
+		}
+		for _, file := range target.MetadataFiles() {
+			fmt.Printf("%s: %s\n", file.ApplicationStrategy(), file.Path())
+		}
 	}
 }
 
+//ApplyResult describes the outcome of TargetFile.Apply() and TargetFile.Plan().
+type ApplyResult int
+
+const (
+	//ApplyChanged means that the target file was (or would be) changed.
+	ApplyChanged ApplyResult = iota
+	//ApplyUnchanged means that the target file is already up-to-date.
+	ApplyUnchanged
+	//ApplyNeedsForce means that the target file was not touched because the
+	//user changed or deleted it, and --force is needed to overwrite it.
+	ApplyNeedsForce
+	//ApplyFailed means that an error occurred.
+	ApplyFailed
+)
+
+//needsForceError is returned by apply() and plan() when they refuse to touch
+//a target file that was changed or deleted by the user.
+type needsForceError string
+
+func (e needsForceError) Error() string {
+	return string(e)
+}
+
 //Apply implements the common.Entity interface.
-func (target *TargetFile) Apply(withForce bool) (skipReport bool) {
-	var err error
+func (target *TargetFile) Apply(withForce bool) ApplyResult {
+	if target.directory {
+		return resultFrom(applyDirectory(target, withForce))
+	}
 	if target.orphaned {
-		err = target.handleOrphanedTargetBase()
-		skipReport = false
-	} else {
-		skipReport, err = apply(target, withForce)
+		return resultFrom(false, target.handleOrphanedTargetBase())
 	}
+	return resultFrom(apply(target, withForce))
+}
 
+//Plan is like Apply, but only prints what Apply would do, without changing
+//anything.
+func (target *TargetFile) Plan(withForce bool) ApplyResult {
+	if target.directory {
+		return resultFrom(planDirectory(target, withForce))
+	}
+	if target.orphaned {
+		target.planOrphanedTargetBase()
+		return ApplyChanged
+	}
+	return resultFrom(plan(target, withForce))
+}
+
+//resultFrom reports the given error (if any) and converts the return values of
+//apply() and plan() into an ApplyResult.
+func resultFrom(skipReport bool, err error) ApplyResult {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+		if _, ok := err.(needsForceError); ok {
+			return ApplyNeedsForce
+		}
+		return ApplyFailed
+	}
+	if skipReport {
+		return ApplyUnchanged
 	}
-	return
+	return ApplyChanged
 }
@@ -0,0 +1,122 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"../../internal/toml"
+	"../common"
+	"../platform"
+)
+
+//TemplateData is the data that is available to .holotemplate repo files.
+type TemplateData struct {
+	//Hostname is the hostname of this system.
+	Hostname string
+	//Distribution contains the IDs of the current distribution, as returned
+	//by platform.GetCurrentDistribution().
+	Distribution map[string]bool
+	//Base is the contents of the target base (or, more precisely, the result
+	//of the previous application step).
+	Base string
+	//Vars contains the variables from the host-local data file (see
+	//TemplateVariablesPath()).
+	Vars map[string]interface{}
+}
+
+//TemplateVariablesPath returns the path to the host-local data file that
+//contains the variables for .holotemplate repo files.
+func TemplateVariablesPath() string {
+	return filepath.Join(common.TargetDirectory(), "etc/holo/variables.toml")
+}
+
+//currentHostname returns the hostname of this system, or the value of
+//$HOLO_CURRENT_HOSTNAME if set (for unit tests).
+func currentHostname() (string, error) {
+	if value := os.Getenv("HOLO_CURRENT_HOSTNAME"); value != "" {
+		return value, nil
+	}
+	return os.Hostname()
+}
+
+//readTemplateVariables reads the host-local data file for .holotemplate repo
+//files. If it does not exist, no variables are defined.
+func readTemplateVariables() (map[string]interface{}, error) {
+	path := TemplateVariablesPath()
+	vars := make(map[string]interface{})
+	_, err := toml.DecodeFile(path, &vars)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return vars, nil
+		}
+		return nil, fmt.Errorf("cannot read %s: %s", path, err.Error())
+	}
+	return vars, nil
+}
+
+func applyTemplate(repoFile RepoFile, buffer *FileBuffer) (*FileBuffer, error) {
+	//this application strategy requires file contents
+	buffer, err := buffer.ResolveSymlink()
+	if err != nil {
+		return nil, err
+	}
+
+	//collect the template data
+	hostname, err := currentHostname()
+	if err != nil {
+		return nil, err
+	}
+	vars, err := readTemplateVariables()
+	if err != nil {
+		return nil, err
+	}
+	data := TemplateData{
+		Hostname:     hostname,
+		Distribution: platform.GetCurrentDistribution(),
+		Base:         string(buffer.Contents),
+		Vars:         vars,
+	}
+
+	//render template (references to undefined variables are errors, instead of
+	//silently rendering "<no value>"; the error messages from text/template
+	//already include the path of the repo file)
+	contents, err := ioutil.ReadFile(repoFile.Path())
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(repoFile.Path()).Option("missingkey=error").Parse(string(contents))
+	if err != nil {
+		return nil, err
+	}
+	var result bytes.Buffer
+	err = tmpl.Execute(&result, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFileBufferFromContents(result.Bytes(), buffer.BasePath), nil
+}
@@ -0,0 +1,280 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package impl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"../common"
+)
+
+//defaultKeepVersions is the number of previous versions kept for each target
+//file if $HOLO_FILES_KEEP_VERSIONS is not set.
+const defaultKeepVersions = 5
+
+//Version is a previous version of a target file that can be restored by
+//TargetFile.Rollback(). Provisioned versions are previous results of apply(),
+//base versions are previous target bases that were replaced by an updated
+//target base from the package manager.
+type Version struct {
+	ID   int
+	Kind string //either "provisioned" or "base"
+	path string
+}
+
+//Path returns the path where this version is stored.
+func (v Version) Path() string {
+	return v.path
+}
+
+type versionsByID []Version
+
+func (v versionsByID) Len() int           { return len(v) }
+func (v versionsByID) Less(i, j int) bool { return v[i].ID < v[j].ID }
+func (v versionsByID) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+//keepVersions returns how many previous versions shall be kept for each
+//target file.
+func keepVersions() int {
+	value := os.Getenv("HOLO_FILES_KEEP_VERSIONS")
+	if value == "" {
+		return defaultKeepVersions
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		fmt.Fprintf(os.Stderr, "!! invalid value for HOLO_FILES_KEEP_VERSIONS: %s\n", value)
+		return defaultKeepVersions
+	}
+	return count
+}
+
+//Versions returns the previous versions of this target file that are
+//available for TargetFile.Rollback(), oldest first.
+func (target *TargetFile) Versions() ([]Version, error) {
+	versionsDir := target.PathIn(common.VersionsDirectory())
+	dir, err := os.Open(versionsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, name := range names {
+		//file names look like "3.provisioned" or "4.base"
+		fields := strings.SplitN(name, ".", 2)
+		if len(fields) != 2 || (fields[1] != "provisioned" && fields[1] != "base") {
+			continue
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		versions = append(versions, Version{id, fields[1], filepath.Join(versionsDir, name)})
+	}
+	sort.Sort(versionsByID(versions))
+	return versions, nil
+}
+
+//saveVersion stores a copy of the file at the given path as a new version of
+//this target file, and removes the oldest versions if more than
+//keepVersions() versions exist.
+func (target *TargetFile) saveVersion(kind, path string) error {
+	keep := keepVersions()
+	if keep == 0 {
+		return nil
+	}
+	versions, err := target.Versions()
+	if err != nil {
+		return err
+	}
+
+	nextID := 1
+	if len(versions) > 0 {
+		nextID = versions[len(versions)-1].ID + 1
+	}
+	versionsDir := target.PathIn(common.VersionsDirectory())
+	err = os.MkdirAll(versionsDir, 0755)
+	if err != nil {
+		return err
+	}
+	versionPath := filepath.Join(versionsDir, fmt.Sprintf("%d.%s", nextID, kind))
+	err = common.CopyFile(path, versionPath)
+	if err != nil {
+		return err
+	}
+
+	//the new version is not included in the list yet, so keep one less
+	for len(versions) > keep-1 {
+		err := os.Remove(versions[0].path)
+		if err != nil {
+			return err
+		}
+		versions = versions[1:]
+	}
+	return nil
+}
+
+//Rollback restores a previous version of this target file. If versionID is 0,
+//the most recent version is restored. For a provisioned version, its contents
+//are written to the target path directly. For a base version, the target
+//base is restored and the repository entries are applied to it again.
+func (target *TargetFile) Rollback(versionID int, withForce bool) ApplyResult {
+	if target.directory {
+		return resultFrom(false, fmt.Errorf("cannot roll back %s: is a directory", target.EntityID()))
+	}
+	if target.orphaned {
+		return resultFrom(false, fmt.Errorf("cannot roll back %s: target base is orphaned", target.EntityID()))
+	}
+	return resultFrom(rollback(target, versionID, withForce))
+}
+
+func rollback(target *TargetFile, versionID int, withForce bool) (skipReport bool, err error) {
+	targetPath := target.PathIn(common.TargetDirectory())
+	targetBasePath := target.PathIn(common.TargetBaseDirectory())
+
+	//find the requested version
+	versions, err := target.Versions()
+	if err != nil {
+		return false, err
+	}
+	if len(versions) == 0 {
+		return false, fmt.Errorf("no previous versions of %s available", targetPath)
+	}
+	version := versions[len(versions)-1]
+	if versionID != 0 {
+		found := false
+		for _, v := range versions {
+			if v.ID == versionID {
+				version, found = v, true
+			}
+		}
+		if !found {
+			return false, fmt.Errorf("no version %d of %s available", versionID, targetPath)
+		}
+	}
+
+	//like apply(), refuse to overwrite changes made by the user
+	lastProvisionedPath := target.PathIn(common.ProvisionedDirectory())
+	if !withForce {
+		if !common.IsManageableFile(targetPath) {
+			return false, needsForceError("skipping target: file has been deleted by user (use --force to restore)")
+		}
+		if common.IsManageableFile(lastProvisionedPath) {
+			targetBuffer, err := NewFileBuffer(targetPath, targetPath)
+			if err != nil {
+				return false, err
+			}
+			lastProvisionedBuffer, err := NewFileBuffer(lastProvisionedPath, targetPath)
+			if err != nil {
+				return false, err
+			}
+			if !targetBuffer.EqualTo(lastProvisionedBuffer) {
+				return false, needsForceError("skipping target: file has been modified by user (use --force to overwrite)")
+			}
+			metadata, err := target.Metadata()
+			if err != nil {
+				return false, err
+			}
+			drifted, err := metadataDrifted(metadata, targetPath, lastProvisionedPath)
+			if err != nil {
+				return false, err
+			}
+			if drifted {
+				return false, needsForceError("skipping target: file metadata has been modified by user (use --force to overwrite)")
+			}
+		}
+	}
+
+	//restoring a target base requires applying the repository entries again
+	if version.Kind == "base" {
+		return false, target.rollbackTargetBase(version)
+	}
+
+	//load the contents that shall be restored (before saving new versions,
+	//which might remove the one that is restored)
+	buffer, err := NewFileBuffer(version.path, targetPath)
+	if err != nil {
+		return false, err
+	}
+	//don't do anything more if the target is already in this state
+	if !withForce && common.IsManageableFile(lastProvisionedPath) {
+		lastProvisionedBuffer, err := NewFileBuffer(lastProvisionedPath, targetPath)
+		if err != nil {
+			return false, err
+		}
+		if buffer.EqualTo(lastProvisionedBuffer) {
+			return true, nil
+		}
+	}
+
+	fmt.Printf("restoring provisioned version %d\n", version.ID)
+	return false, target.provision(buffer, targetBasePath)
+}
+
+//rollbackTargetBase restores the given target base version, and applies the
+//repository entries to it again. The current target base is only replaced if
+//the new target file could be provisioned.
+func (target *TargetFile) rollbackTargetBase(version Version) error {
+	targetBasePath := target.PathIn(common.TargetBaseDirectory())
+	fmt.Printf("restoring target base version %d\n", version.ID)
+
+	//restore the target base next to the current one (the version might be
+	//removed by saveVersion() while provisioning the target)
+	baseBuffer, err := NewFileBuffer(version.path, targetBasePath)
+	if err != nil {
+		return err
+	}
+	newTargetBasePath := targetBasePath + ".holonew"
+	err = baseBuffer.Write(newTargetBasePath)
+	if err == nil {
+		err = common.ApplyFilePermissions(version.path, newTargetBasePath)
+	}
+	var buffer *FileBuffer
+	if err == nil {
+		buffer, err = target.render(newTargetBasePath)
+	}
+	if err == nil {
+		err = target.provision(buffer, newTargetBasePath)
+	}
+	if err != nil {
+		_ = os.Remove(newTargetBasePath) //this can fail silently
+		return err
+	}
+
+	//keep the current target base, so that the rollback can be undone
+	err = target.saveVersion("base", targetBasePath)
+	if err != nil {
+		return err
+	}
+	return os.Rename(newTargetBasePath, targetBasePath)
+}
@@ -21,9 +21,13 @@
 package main
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
 
+	"./common"
 	"./impl"
 )
 
@@ -37,8 +41,16 @@ func init() {
 }
 
 func main() {
-	if version := os.Getenv("HOLO_API_VERSION"); version != "1" {
-		fmt.Fprintf(os.Stderr, "!! holo-users-groups plugin called with unknown HOLO_API_VERSION %s\n", version)
+	//the info operation is called before HOLO_API_VERSION is known
+	if os.Args[1] == "info" {
+		fmt.Println("MIN_API_VERSION: 1")
+		fmt.Println("MAX_API_VERSION: 2")
+		fmt.Println("CAPABILITIES: diff pending-diff plan rollback")
+		return
+	}
+
+	if version := os.Getenv("HOLO_API_VERSION"); version != "1" && version != "2" {
+		fmt.Fprintf(os.Stderr, "!! holo-files plugin called with unknown HOLO_API_VERSION %s\n", version)
 	}
 
 	//scan for entities
@@ -75,21 +87,125 @@ func main() {
 		applyEntity(selectedEntity, false)
 	case "force-apply":
 		applyEntity(selectedEntity, true)
+	case "plan":
+		planEntity(selectedEntity, false)
+	case "force-plan":
+		planEntity(selectedEntity, true)
+	case "rollback":
+		rollbackEntity(selectedEntity, false)
+	case "force-rollback":
+		rollbackEntity(selectedEntity, true)
+	case "versions":
+		versions, err := selectedEntity.Versions()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+			os.Exit(1)
+		}
+		for _, version := range versions {
+			kind := "provisioned version"
+			if version.Kind == "base" {
+				kind = "target base"
+			}
+			fmt.Printf("%d: %s (sha256: %s)\n", version.ID, kind, hashFile(version.Path()))
+		}
 	case "diff":
 		output, err := selectedEntity.RenderDiff()
+		os.Stdout.Write(output)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+			os.Exit(1)
 		}
+	case "pending-diff":
+		output, err := selectedEntity.RenderPendingDiff()
 		os.Stdout.Write(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+			os.Exit(1)
+		}
 	}
 }
 
 func applyEntity(entity *impl.TargetFile, withForce bool) {
-	skipReport := entity.Apply(withForce)
-	if skipReport {
-		_, err := os.NewFile(3, "file descriptor 3").Write([]byte("not changed\n"))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+	reportResult(withJournal(entity, func() impl.ApplyResult {
+		return entity.Apply(withForce)
+	}))
+}
+
+func rollbackEntity(entity *impl.TargetFile, withForce bool) {
+	//the version ID is optional (default: the most recent version)
+	versionID := 0
+	if len(os.Args) > 3 {
+		var err error
+		versionID, err = strconv.Atoi(os.Args[3])
+		if err != nil || versionID <= 0 {
+			fmt.Fprintf(os.Stderr, "!! invalid version \"%s\"\n", os.Args[3])
+			os.Exit(1)
 		}
 	}
+
+	reportResult(withJournal(entity, func() impl.ApplyResult {
+		return entity.Rollback(versionID, withForce)
+	}))
+}
+
+func planEntity(entity *impl.TargetFile, withForce bool) {
+	reportResult(entity.Plan(withForce))
+}
+
+//withJournal runs the given operation on the entity. If the target file was
+//changed, the hashes of the target file before and after the operation are
+//recorded in the journal of `holo apply`.
+func withJournal(entity *impl.TargetFile, operation func() impl.ApplyResult) impl.ApplyResult {
+	targetPath := entity.PathIn(common.TargetDirectory())
+	hashBefore := hashFile(targetPath)
+	result := operation()
+	if result == impl.ApplyChanged {
+		if hashBefore != "" {
+			sendCommand("journal: sha256-old=" + hashBefore)
+		}
+		if hashAfter := hashFile(targetPath); hashAfter != "" {
+			sendCommand("journal: sha256-new=" + hashAfter)
+		}
+	}
+	return result
+}
+
+//reportResult sends the result of an apply or plan operation to Holo via file
+//descriptor 3, and exits with non-zero status if the operation did not succeed.
+func reportResult(result impl.ApplyResult) {
+	switch result {
+	case impl.ApplyUnchanged:
+		sendCommand("not changed")
+	case impl.ApplyNeedsForce:
+		sendCommand("requires --force")
+		os.Exit(1)
+	case impl.ApplyFailed:
+		os.Exit(1)
+	}
+}
+
+//hashFile returns the hex-encoded SHA-256 hash of the file at the given path
+//(or of the link target, for symlinks), or an empty string if the file cannot
+//be read.
+func hashFile(path string) string {
+	var contents []byte
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		var linkTarget string
+		linkTarget, err = os.Readlink(path)
+		contents = []byte(linkTarget)
+	} else if err == nil {
+		contents, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(contents))
+}
+
+func sendCommand(command string) {
+	_, err := os.NewFile(3, "file descriptor 3").Write([]byte(command + "\n"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+	}
 }
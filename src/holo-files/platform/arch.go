@@ -33,6 +33,14 @@ func (p archImpl) FindUpdatedTargetBase(targetPath string) (actualPath, reported
 	return "", "", nil
 }
 
+func (p archImpl) ProbeUpdatedTargetBase(targetPath string) (basePath, currentTargetPath, reportedPath string) {
+	pacnewPath := targetPath + ".pacnew"
+	if common.IsManageableFile(pacnewPath) {
+		return pacnewPath, targetPath, pacnewPath
+	}
+	return "", targetPath, ""
+}
+
 func (p archImpl) AdditionalCleanupTargets(targetPath string) []string {
 	pacsavePath := targetPath + ".pacsave"
 	if common.IsManageableFile(pacsavePath) {
@@ -45,6 +45,13 @@ type Impl interface {
 	//is the original path to the updated target base, and the actualPath is
 	//where Holo will find the file.
 	FindUpdatedTargetBase(targetPath string) (actualPath, reportedPath string, err error)
+	//ProbeUpdatedTargetBase is the read-only counterpart of
+	//FindUpdatedTargetBase, used when predicting what `holo apply` would do.
+	//It must not move any files around. Instead, it returns where the updated
+	//target base can be found right now (or "" if there is none), where the
+	//current target can be found right now (usually the targetPath), and the
+	//reportedPath as described above.
+	ProbeUpdatedTargetBase(targetPath string) (basePath, currentTargetPath, reportedPath string)
 	//AdditionalCleanupTargets is called as part of the orphan handling. When
 	//an application package is removed, but one of its configuration files has
 	//been modified by Holo, the system package manager will usually retain a
@@ -54,6 +54,22 @@ func (p dpkgImpl) FindUpdatedTargetBase(targetPath string) (actualPath, reported
 	return "", "", nil
 }
 
+func (p dpkgImpl) ProbeUpdatedTargetBase(targetPath string) (basePath, currentTargetPath, reportedPath string) {
+	dpkgDistPath := targetPath + ".dpkg-dist"
+	dpkgOldPath := targetPath + ".dpkg-old"
+
+	//if "${target}.dpkg-old" exists, FindUpdatedTargetBase would swap it with
+	//$target, so the updated target base is currently at $target
+	if common.IsManageableFile(dpkgOldPath) {
+		return targetPath, dpkgOldPath, fmt.Sprintf("%s (with .dpkg-old)", targetPath)
+	}
+
+	if common.IsManageableFile(dpkgDistPath) {
+		return dpkgDistPath, targetPath, dpkgDistPath
+	}
+	return "", targetPath, ""
+}
+
 func (p dpkgImpl) AdditionalCleanupTargets(targetPath string) []string {
 	//not used by dpkg
 	return []string{}
@@ -28,6 +28,10 @@ func (p genericImpl) FindUpdatedTargetBase(targetPath string) (actualPath, repor
 	return "", "", nil
 }
 
+func (p genericImpl) ProbeUpdatedTargetBase(targetPath string) (basePath, currentTargetPath, reportedPath string) {
+	return "", targetPath, ""
+}
+
 func (p genericImpl) AdditionalCleanupTargets(targetPath string) []string {
 	return nil
 }
@@ -54,6 +54,22 @@ func (p rpmImpl) FindUpdatedTargetBase(targetPath string) (actualPath, reportedP
 	return "", "", nil
 }
 
+func (p rpmImpl) ProbeUpdatedTargetBase(targetPath string) (basePath, currentTargetPath, reportedPath string) {
+	rpmnewPath := targetPath + ".rpmnew"
+	rpmsavePath := targetPath + ".rpmsave"
+
+	//if "${target}.rpmsave" exists, FindUpdatedTargetBase would swap it with
+	//$target, so the updated target base is currently at $target
+	if common.IsManageableFile(rpmsavePath) {
+		return targetPath, rpmsavePath, fmt.Sprintf("%s (with .rpmsave)", targetPath)
+	}
+
+	if common.IsManageableFile(rpmnewPath) {
+		return rpmnewPath, targetPath, rpmnewPath
+	}
+	return "", targetPath, ""
+}
+
 func (p rpmImpl) AdditionalCleanupTargets(targetPath string) []string {
 	//not used by RPM
 	return []string{}
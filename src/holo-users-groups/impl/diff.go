@@ -23,53 +23,65 @@ package impl
 import (
 	"bytes"
 	"fmt"
-	"strconv"
 	"strings"
 
 	"../../internal/toml"
+	"../../shared/textdiff"
 )
 
 //RenderDiff implements the Entity interface.
 func (group Group) RenderDiff() ([]byte, error) {
-	//does this group exist already?
-	groupExists, actualGid, err := group.checkExists()
+	diff, err := group.collectDiff()
 	if err != nil {
 		return nil, err
 	}
+	return diff.Render(generateDiffHeader("group", group.EntityID(), diff.EntityExists))
+}
 
-	//to simplify the diff process, replace a non-existing group by an empty group
-	headers := generateDiffHeader("group", group.EntityID(), groupExists)
-
-	//generate body
-	var lines []string
-	if groupExists {
-		lines = []string{" [[group]]"}
-	} else {
-		lines = []string{"-[[group]]"}
+//RenderPendingDiff implements the Entity interface.
+func (group Group) RenderPendingDiff() ([]byte, error) {
+	diff, err := group.collectDiff()
+	if err != nil {
+		return nil, err
 	}
+	return diff.Reversed().Render(generatePendingDiffHeader("group", group.EntityID(), diff.EntityExists))
+}
 
-	lines, err = addDiffForField(lines, groupExists, "name", group.Name, group.Name, "")
+func (group Group) collectDiff() (*entityDiff, error) {
+	//does this group exist already?
+	groupExists, actualGid, err := group.checkExists()
 	if err != nil {
 		return nil, err
 	}
-	lines, err = addDiffForField(lines, groupExists, "gid", group.GID, actualGid, 0)
+
+	diff := newEntityDiff("[[group]]", groupExists)
+	err = diff.addField("name", group.Name, group.Name, "")
 	if err != nil {
 		return nil, err
 	}
+	err = diff.addField("gid", group.GID, actualGid, 0)
+	return diff, err
+}
 
-	//is there any diff?
-	if !hasDiff(lines) {
-		return nil, nil
+//RenderDiff implements the Entity interface.
+func (user User) RenderDiff() ([]byte, error) {
+	diff, err := user.collectDiff()
+	if err != nil {
+		return nil, err
 	}
+	return diff.Render(generateDiffHeader("user", user.EntityID(), diff.EntityExists))
+}
 
-	//count lines for "@@ -from +to" hunk header
-	hunkHeader := generateHunkHeader(lines)
-	allLines := append(append(headers, hunkHeader), lines...)
-	return []byte(strings.Join(allLines, "\n") + "\n"), nil
+//RenderPendingDiff implements the Entity interface.
+func (user User) RenderPendingDiff() ([]byte, error) {
+	diff, err := user.collectDiff()
+	if err != nil {
+		return nil, err
+	}
+	return diff.Reversed().Render(generatePendingDiffHeader("user", user.EntityID(), diff.EntityExists))
 }
 
-//RenderDiff implements the Entity interface.
-func (user User) RenderDiff() ([]byte, error) {
+func (user User) collectDiff() (*entityDiff, error) {
 	//does this user exist already?
 	userExists, actualUser, err := user.checkExists()
 	if err != nil {
@@ -80,54 +92,34 @@ func (user User) RenderDiff() ([]byte, error) {
 	if !userExists {
 		actualUser = &User{}
 	}
-	headers := generateDiffHeader("user", user.EntityID(), userExists)
-
-	//generate body
-	var lines []string
-	if userExists {
-		lines = []string{" [[user]]"}
-	} else {
-		lines = []string{"-[[user]]"}
-	}
 
-	lines, err = addDiffForField(lines, userExists, "name", user.Name, user.Name, "")
+	diff := newEntityDiff("[[user]]", userExists)
+	err = diff.addField("name", user.Name, user.Name, "")
 	if err != nil {
 		return nil, err
 	}
-	lines, err = addDiffForField(lines, userExists, "comment", user.Comment, actualUser.Comment, "")
+	err = diff.addField("comment", user.Comment, actualUser.Comment, "")
 	if err != nil {
 		return nil, err
 	}
-	lines, err = addDiffForField(lines, userExists, "uid", user.UID, actualUser.UID, 0)
+	err = diff.addField("uid", user.UID, actualUser.UID, 0)
 	if err != nil {
 		return nil, err
 	}
-	lines, err = addDiffForField(lines, userExists, "home", user.HomeDirectory, actualUser.HomeDirectory, "")
+	err = diff.addField("home", user.HomeDirectory, actualUser.HomeDirectory, "")
 	if err != nil {
 		return nil, err
 	}
-	lines, err = addDiffForField(lines, userExists, "group", user.Group, actualUser.Group, "")
+	err = diff.addField("group", user.Group, actualUser.Group, "")
 	if err != nil {
 		return nil, err
 	}
-	lines, err = addDiffForField(lines, userExists, "groups", user.Groups, actualUser.Groups, []string{})
+	err = diff.addField("groups", user.Groups, actualUser.Groups, []string{})
 	if err != nil {
 		return nil, err
 	}
-	lines, err = addDiffForField(lines, userExists, "shell", user.Shell, actualUser.Shell, "")
-	if err != nil {
-		return nil, err
-	}
-
-	//is there any diff?
-	if !hasDiff(lines) {
-		return nil, nil
-	}
-
-	//count lines for "@@ -from +to" hunk header
-	hunkHeader := generateHunkHeader(lines)
-	allLines := append(append(headers, hunkHeader), lines...)
-	return []byte(strings.Join(allLines, "\n") + "\n"), nil
+	err = diff.addField("shell", user.Shell, actualUser.Shell, "")
+	return diff, err
 }
 
 func generateDiffHeader(entityType, entityID string, entityExists bool) []string {
@@ -146,69 +138,83 @@ func generateDiffHeader(entityType, entityID string, entityExists bool) []string
 	return append(headers, "+++ /dev/null")
 }
 
-func generateHunkHeader(lines []string) string {
-	//count lines for "@@ -from +to" hunk header
-	fromLines, toLines := 0, 0
-	for _, line := range lines {
-		switch line[0] {
-		case ' ':
-			fromLines++
-			toLines++
-		case '-':
-			fromLines++
-		case '+':
-			toLines++
-		}
-	}
-	return fmt.Sprintf("@@ -%s +%s", lineCountToString(fromLines), lineCountToString(toLines))
+func generatePendingDiffHeader(entityType, entityID string, entityExists bool) []string {
+	//like generateDiffHeader, but the entity definition is on the "+" side
+	headers := []string{
+		fmt.Sprintf("diff --holo %s", entityID),
+	}
+	if !entityExists {
+		return append(headers, "new "+entityType, "--- /dev/null", fmt.Sprintf("+++ %s", entityID))
+	}
+	return append(headers, fmt.Sprintf("--- %s", entityID), fmt.Sprintf("+++ %s", entityID))
 }
 
-func lineCountToString(count int) string {
-	//format line count for use in hunk header of unified diff
-	switch count {
-	case 0:
-		return "0,0"
-	case 1:
-		return "1"
-	default:
-		return "1," + strconv.Itoa(count)
+//entityDiff collects the lines for the diff of an entity: On the "from" side,
+//the entity definition; on the "to" side, the actual state of the entity (or
+//nothing if the entity does not exist).
+type entityDiff struct {
+	EntityExists bool
+	From, To     []string
+}
+
+func newEntityDiff(header string, entityExists bool) *entityDiff {
+	d := &entityDiff{EntityExists: entityExists, From: []string{header + "\n"}}
+	if entityExists {
+		d.To = []string{header + "\n"}
 	}
+	return d
 }
 
 //Produce a content diff for the given field, by encoding the expectedValue and
 //actualValue as TOML. No output is produced if the expectedValue matches the
 //ignoredValue, which means that the value is not set in the entity definition.
-func addDiffForField(lines []string, entityExists bool, field string, expectedValue, actualValue, ignoredValue interface{}) ([]string, error) {
+func (d *entityDiff) addField(field string, expectedValue, actualValue, ignoredValue interface{}) error {
 	//encode values into TOML
 	expectedData, err := encodeField(field, expectedValue)
 	if err != nil {
-		return lines, err
+		return err
 	}
 	ignoredData, err := encodeField(field, ignoredValue)
 	if err != nil {
-		return lines, err
+		return err
 	}
 	if expectedData == ignoredData {
 		//this field is not included in the entity definition, so don't print it in the diff
-		return lines, nil
+		return nil
 	}
+	d.From = append(d.From, expectedData+"\n")
 
-	//early exit if there is no previous entity, i.e. we print a diff with "-" lines only
-	if !entityExists {
-		return append(lines, "-"+expectedData), nil
+	//if there is no previous entity, we print a diff with "-" lines only
+	if !d.EntityExists {
+		return nil
 	}
-
-	//encode actual value into TOML
 	actualData, err := encodeField(field, actualValue)
 	if err != nil {
-		return lines, err
+		return err
 	}
+	d.To = append(d.To, actualData+"\n")
+	return nil
+}
 
-	//if both are the same, print as context, else print as diff
-	if expectedData == actualData {
-		return append(lines, " "+expectedData), nil
+//Reversed returns an entityDiff where the "from" and "to" sides are swapped,
+//i.e. a diff that shows what Apply() would change.
+func (d *entityDiff) Reversed() *entityDiff {
+	return &entityDiff{EntityExists: d.EntityExists, From: d.To, To: d.From}
+}
+
+//Render produces the complete diff (or nothing if there are no differences).
+func (d *entityDiff) Render(headers []string) ([]byte, error) {
+	opts, err := textdiff.OptionsFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	//an entity has only a few lines, so show all of them
+	opts.Context = len(d.From) + len(d.To)
+	body := textdiff.Render(d.From, d.To, opts)
+	if len(body) == 0 {
+		return nil, nil
 	}
-	return append(lines, "-"+expectedData, "+"+actualData), nil
+	return append([]byte(strings.Join(headers, "\n")+"\n"), body...), nil
 }
 
 func encodeField(field string, value interface{}) (string, error) {
@@ -216,13 +222,3 @@ func encodeField(field string, value interface{}) (string, error) {
 	err := toml.NewEncoder(&buf).Encode(map[string]interface{}{field: value})
 	return strings.TrimSpace(buf.String()), err
 }
-
-//Check if the diff contains any differences (i.e. lines that are not context lines).
-func hasDiff(lines []string) bool {
-	for _, line := range lines {
-		if line[0] != ' ' {
-			return true
-		}
-	}
-	return false
-}
@@ -33,14 +33,32 @@ type Entity interface {
 	//PrintReport prints the scan report for this entity on stdout.
 	PrintReport()
 	//Apply performs the complete application algorithm for the given Entity.
-	Apply(withForce bool) (entityWasChanged bool)
+	Apply(withForce bool) ApplyResult
 	//RenderDiff creates a unified diff between the current and last
 	//provisioned version of this entity. For files, the output is always a
 	//patch that can be applied on the last provisioned version to obtain the
 	//current state.
 	RenderDiff() ([]byte, error)
+	//RenderPendingDiff creates a unified diff between the current state of
+	//this entity and the state that Apply(true) would produce.
+	RenderPendingDiff() ([]byte, error)
 }
 
+//ApplyResult describes the outcome of Entity.Apply().
+type ApplyResult int
+
+const (
+	//ApplyChanged means that the entity was (or would be) changed.
+	ApplyChanged ApplyResult = iota
+	//ApplyUnchanged means that the entity is already up-to-date.
+	ApplyUnchanged
+	//ApplyNeedsForce means that the entity was not touched because its actual
+	//attributes differ from the definition, and --force is needed to fix them.
+	ApplyNeedsForce
+	//ApplyFailed means that an error occurred.
+	ApplyFailed
+)
+
 //Entities holds a slice of Entity instances, and implements some methods to
 //satisfy the sort.Interface interface.
 type Entities []Entity
@@ -81,12 +81,12 @@ type groupDiff struct {
 //Apply performs the complete application algorithm for the given Entity.
 //If the group does not exist yet, it is created. If it does exist, but some
 //attributes do not match, it will be updated, but only if withForce is given.
-func (g Group) Apply(withForce bool) (entityHasChanged bool) {
+func (g Group) Apply(withForce bool) ApplyResult {
 	//check if we have that group already
 	groupExists, actualGid, err := g.checkExists()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "!! Cannot read group database: %s\n", err.Error())
-		return false
+		return ApplyFailed
 	}
 
 	//check if the actual properties diverge from our definition
@@ -104,24 +104,25 @@ func (g Group) Apply(withForce bool) (entityHasChanged bool) {
 				err := g.callGroupmod()
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
-					return false
+					return ApplyFailed
 				}
-				return true
+				return ApplyChanged
 			}
 			for _, diff := range differences {
 				fmt.Fprintf(os.Stderr, "!! Group has %s: %s, expected %s (use --force to overwrite)\n", diff.field, diff.actual, diff.expected)
 			}
+			return ApplyNeedsForce
 		}
-		return false
+		return ApplyUnchanged
 	}
 
 	//create the group if it does not exist
 	err = g.callGroupadd()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
-		return false
+		return ApplyFailed
 	}
-	return true
+	return ApplyChanged
 }
 
 func (g Group) checkExists() (exists bool, gid int, e error) {
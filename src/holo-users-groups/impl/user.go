@@ -102,12 +102,12 @@ type userDiff struct {
 //Apply performs the complete application algorithm for the given Entity.
 //If the user does not exist yet, it is created. If it does exist, but some
 //attributes do not match, it will be updated, but only if withForce is given.
-func (u User) Apply(withForce bool) (entityHasChanged bool) {
+func (u User) Apply(withForce bool) ApplyResult {
 	//check if we have that group already
 	userExists, actualUser, err := u.checkExists()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "!! Cannot read user database: %s\n", err.Error())
-		return false
+		return ApplyFailed
 	}
 
 	//check if the actual properties diverge from our definition
@@ -147,24 +147,25 @@ func (u User) Apply(withForce bool) (entityHasChanged bool) {
 				err := u.callUsermod()
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
-					return false
+					return ApplyFailed
 				}
-				return true
+				return ApplyChanged
 			}
 			for _, diff := range differences {
 				fmt.Fprintf(os.Stderr, "!! User has %s: %s, expected %s (use --force to overwrite)\n", diff.field, diff.actual, diff.expected)
 			}
+			return ApplyNeedsForce
 		}
-		return false
+		return ApplyUnchanged
 	}
 
 	//create the user if it does not exist
 	err = u.callUseradd()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
-		return false
+		return ApplyFailed
 	}
-	return true
+	return ApplyChanged
 }
 
 //checkExists checks if the user exists in /etc/passwd. If it does, its actual
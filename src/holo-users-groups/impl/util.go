@@ -59,6 +59,7 @@ func Getent(databaseFile string, predicate func([]string) bool) ([]string, error
 
 var rootDir string
 var mock bool
+var dryRun bool
 
 func init() {
 	rootDir = os.Getenv("HOLO_ROOT_DIR")
@@ -74,10 +75,21 @@ func GetPath(path string) string {
 	return filepath.Join(rootDir, path)
 }
 
+//EnableDryRun makes all subsequent ExecProgramOrMock calls print the command
+//line that would be executed, instead of executing the command. This is used
+//for the "plan" operation.
+func EnableDryRun() {
+	dryRun = true
+}
+
 //ExecProgramOrMock is a wrapper around exec.Command().Run() that, if run in a
 //test environment, only prints the command line instead of executing the
 //command.
 func ExecProgramOrMock(command string, arguments ...string) (err error) {
+	if dryRun {
+		fmt.Printf("would run: %s %s\n", command, shellEscapeArgs(arguments))
+		return nil
+	}
 	if mock {
 		fmt.Printf("MOCK: %s %s\n", command, shellEscapeArgs(arguments))
 		return nil
@@ -31,7 +31,15 @@ import (
 )
 
 func main() {
-	if version := os.Getenv("HOLO_API_VERSION"); version != "1" {
+	//the info operation is called before HOLO_API_VERSION is known
+	if os.Args[1] == "info" {
+		fmt.Println("MIN_API_VERSION: 1")
+		fmt.Println("MAX_API_VERSION: 2")
+		fmt.Println("CAPABILITIES: diff pending-diff plan")
+		return
+	}
+
+	if version := os.Getenv("HOLO_API_VERSION"); version != "1" && version != "2" {
 		fmt.Fprintf(os.Stderr, "!! holo-users-groups plugin called with unknown HOLO_API_VERSION %s\n", version)
 	}
 
@@ -120,21 +128,44 @@ func executeNonScanCommand() {
 		applyEntity(selectedEntity, false)
 	case "force-apply":
 		applyEntity(selectedEntity, true)
+	case "plan":
+		impl.EnableDryRun()
+		applyEntity(selectedEntity, false)
+	case "force-plan":
+		impl.EnableDryRun()
+		applyEntity(selectedEntity, true)
 	case "diff":
 		output, err := selectedEntity.RenderDiff()
+		os.Stdout.Write(output)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+			os.Exit(1)
 		}
+	case "pending-diff":
+		output, err := selectedEntity.RenderPendingDiff()
 		os.Stdout.Write(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+			os.Exit(1)
+		}
 	}
 }
 
 func applyEntity(entity impl.Entity, withForce bool) {
-	entityHasChanged := entity.Apply(withForce)
-	if !entityHasChanged {
-		_, err := os.NewFile(3, "file descriptor 3").Write([]byte("not changed\n"))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
-		}
+	switch entity.Apply(withForce) {
+	case impl.ApplyUnchanged:
+		sendCommand("not changed")
+	case impl.ApplyNeedsForce:
+		sendCommand("requires --force")
+		os.Exit(1)
+	case impl.ApplyFailed:
+		os.Exit(1)
+	}
+}
+
+func sendCommand(command string) {
+	_, err := os.NewFile(3, "file descriptor 3").Write([]byte(command + "\n"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
 	}
 }
@@ -0,0 +1,118 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"./plugins"
+)
+
+//commandHistory implements `holo history`. Since it does not work on the
+//current entities, it does its own argument parsing.
+func commandHistory(args []string) int {
+	withJSON := false
+	var patterns []string
+	for _, arg := range args {
+		if arg == "--format=json" {
+			withJSON = true
+		} else {
+			patterns = append(patterns, arg)
+		}
+	}
+
+	runs, warnings, err := plugins.ReadHistory()
+	if err != nil {
+		r := plugins.Report{Action: "read", Target: plugins.HistoryDirectory()}
+		r.AddError(err.Error())
+		r.Print()
+		return exitFailed
+	}
+	if len(warnings) > 0 {
+		r := plugins.Report{Action: "read", Target: plugins.HistoryDirectory()}
+		for _, warning := range warnings {
+			r.AddWarning(warning)
+		}
+		r.Print()
+	}
+
+	exitCode := exitSuccess
+	for _, run := range runs {
+		//only show entities that match the given patterns (if any)
+		var entries []plugins.HistoryEntry
+		for _, entry := range run.Entities {
+			if len(patterns) == 0 || matchesAnyPattern(entry.EntityID, patterns) {
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		if withJSON {
+			filteredRun := *run
+			filteredRun.Entities = entries
+			data, err := json.Marshal(filteredRun)
+			if err != nil {
+				r := plugins.Report{Action: "Apply run at", Target: run.Started.Format(time.RFC3339)}
+				r.AddError(err.Error())
+				r.Print()
+				exitCode = exitFailed
+				continue
+			}
+			fmt.Println(string(data))
+			continue
+		}
+
+		r := plugins.Report{
+			Action: "Apply run at",
+			Target: run.Started.Local().Format("2006-01-02 15:04:05 MST"),
+			State:  "holo " + strings.Join(run.Command, " "),
+		}
+		for _, entry := range entries {
+			r.AddLine(entry.Result.String(), entry.EntityID)
+			//when looking at specific entities, show all details
+			if len(patterns) > 0 {
+				for _, warning := range entry.Warnings {
+					r.AddLine("warning", warning)
+				}
+				for _, err := range entry.Errors {
+					r.AddLine("error", err)
+				}
+				keys := make([]string, 0, len(entry.Journal))
+				for key := range entry.Journal {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					r.AddLine(key, entry.Journal[key])
+				}
+			}
+		}
+		r.Print()
+	}
+
+	return exitCode
+}
@@ -0,0 +1,94 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"./plugins"
+)
+
+//runHook runs the given hook with the given additional environment
+//variables. If the hook runs longer than the given timeout (0 means no
+//limit), it is terminated and counts as failed. Its output is reported along
+//with errors, if any. In JSON mode, only failed hooks are reported (on
+//stderr). Returns false if the hook failed.
+func runHook(stage string, hook plugins.Hook, env []string, timeout time.Duration, withJSON bool) bool {
+	output, err := plugins.RunHook(stage, hook.Command, append(os.Environ(), env...), timeout)
+
+	if err == nil && (withJSON || len(output) == 0) {
+		return true
+	}
+	r := plugins.Report{Action: "Running", Target: stage + " hook", State: hook.Command}
+	r.AddLog(string(output))
+	if err != nil {
+		r.AddError("%s hook failed: %s", stage, err.Error())
+	}
+	r.Print()
+	return err == nil
+}
+
+//runPreApplyHooks runs all pre-apply hooks that apply to the given entity (or
+//the global pre-apply hooks, if entity is nil). Returns the command of the
+//first hook that failed, or an empty string if all hooks succeeded.
+func runPreApplyHooks(hooks []plugins.Hook, entity *plugins.Entity, timeout time.Duration, withJSON bool) string {
+	for _, hook := range hooks {
+		var env []string
+		if entity == nil {
+			if hook.Pattern != "" {
+				continue
+			}
+		} else {
+			if hook.Pattern == "" || !matchesEntityPattern(entity.EntityID(), hook.Pattern) {
+				continue
+			}
+			env = []string{"HOLO_ENTITY=" + entity.EntityID()}
+		}
+		if !runHook("pre-apply", hook, env, timeout, withJSON) {
+			return hook.Command
+		}
+	}
+	return ""
+}
+
+//runPostApplyHooks runs all post-apply hooks that apply to at least one of
+//the changed entities. Returns false if any hook failed.
+func runPostApplyHooks(hooks []plugins.Hook, changedIDs []string, timeout time.Duration, withJSON bool) bool {
+	success := true
+	for _, hook := range hooks {
+		var matchingIDs []string
+		for _, id := range changedIDs {
+			if hook.Pattern == "" || matchesEntityPattern(id, hook.Pattern) {
+				matchingIDs = append(matchingIDs, id)
+			}
+		}
+		if len(matchingIDs) == 0 {
+			continue
+		}
+		env := []string{"HOLO_CHANGED_ENTITIES=" + strings.Join(matchingIDs, "\n")}
+		if !runHook("post-apply", hook, env, timeout, withJSON) {
+			success = false
+		}
+	}
+	return success
+}
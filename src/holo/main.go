@@ -23,6 +23,9 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"./plugins"
 )
@@ -40,9 +43,26 @@ func init() {
 //change the format, adjust the Makefile too.
 var version = "v0.10.0-pre"
 
+//Exit codes (see holo(8)).
+const (
+	exitSuccess        = 0
+	exitFailed         = 1
+	exitNeedsForce     = 2
+	exitChangesPending = 3
+	exitInterrupted    = 130
+	exitFatal          = 255
+)
+
 const (
 	optionApplyForce = iota
 	optionScanShort
+	optionFormatJSON
+	optionApplyDryRun
+	optionRollbackList
+	optionWait
+	optionDiffWords
+	optionDiffSideBySide
+	optionDiffPending
 )
 
 func main() {
@@ -53,17 +73,63 @@ func main() {
 	}
 
 	//check that it is a known command word
-	var command func([]*plugins.Entity, map[int]bool)
+	var command func([]*plugins.Entity, map[int]bool) int
 	knownOpts := make(map[string]int)
+	commandValueOpts := make(map[string]*[]string)
+	needsDependencyOrder := false
+	needsSelection := false
+	needsLock := false
+	var rollbackVersions []string
+	var diffContext []string
+	var config *plugins.Configuration
 	switch os.Args[1] {
 	case "apply":
-		command = commandApply
-		knownOpts = map[string]int{"-f": optionApplyForce, "--force": optionApplyForce}
+		command = func(entities []*plugins.Entity, options map[int]bool) int {
+			return commandApply(entities, options, config)
+		}
+		needsDependencyOrder = true
+		needsLock = !containsString(os.Args[2:], "-n") && !containsString(os.Args[2:], "--dry-run")
+		knownOpts = map[string]int{
+			"-f": optionApplyForce, "--force": optionApplyForce,
+			"-n": optionApplyDryRun, "--dry-run": optionApplyDryRun,
+			"--format=json": optionFormatJSON,
+			"--wait":        optionWait,
+		}
+	case "check":
+		command = commandCheck
+		needsDependencyOrder = true
+		knownOpts = map[string]int{"-f": optionApplyForce, "--force": optionApplyForce, "--format=json": optionFormatJSON}
 	case "diff":
-		command = commandDiff
+		command = func(entities []*plugins.Entity, options map[int]bool) int {
+			return commandDiff(entities, options, diffContext)
+		}
+		knownOpts = map[string]int{
+			"--format=json":  optionFormatJSON,
+			"--word-diff":    optionDiffWords,
+			"--side-by-side": optionDiffSideBySide,
+			"--pending":      optionDiffPending,
+		}
+		commandValueOpts["-U"] = &diffContext
+		commandValueOpts["--unified"] = &diffContext
 	case "scan":
 		command = commandScan
-		knownOpts = map[string]int{"-s": optionScanShort, "--short": optionScanShort}
+		knownOpts = map[string]int{"-s": optionScanShort, "--short": optionScanShort, "--format=json": optionFormatJSON}
+	case "rollback":
+		command = func(entities []*plugins.Entity, options map[int]bool) int {
+			return commandRollback(entities, options, rollbackVersions, config)
+		}
+		needsSelection = true
+		needsLock = !containsString(os.Args[2:], "-l") && !containsString(os.Args[2:], "--list")
+		knownOpts = map[string]int{
+			"-f": optionApplyForce, "--force": optionApplyForce,
+			"-l": optionRollbackList, "--list": optionRollbackList,
+			"--wait": optionWait,
+		}
+		commandValueOpts["--to"] = &rollbackVersions
+	case "history":
+		//does not need the configuration or the entities (the entities in the
+		//history might not exist anymore)
+		exit(commandHistory(os.Args[2:]))
 	case "version", "--version":
 		fmt.Println(version)
 		return
@@ -72,107 +138,379 @@ func main() {
 		return
 	}
 
+	//on SIGINT/SIGTERM, stop the running plugin and skip all remaining entities
+	plugins.HandleInterrupts()
+
+	//only one Holo process may change the system state at the same time (the
+	//options have not been parsed yet, but --wait is needed right now)
+	if needsLock {
+		err := plugins.AcquireLock(containsString(os.Args[2:], "--wait"))
+		if err != nil {
+			r := plugins.Report{Action: "Cannot acquire", Target: plugins.LockPath()}
+			r.AddError(err.Error())
+			r.Print()
+			if plugins.Interrupted() {
+				exit(exitInterrupted)
+			}
+			exit(exitFatal)
+		}
+	}
+
 	//load configuration
-	config := plugins.ReadConfiguration()
+	config = plugins.ReadConfiguration()
 	if config == nil {
 		//some fatal error occurred - it was already reported, so just exit
-		os.Exit(255)
+		exit(exitFatal)
 	}
 
 	//ask all plugins to scan for entities
-	var entities []*plugins.Entity
-	for _, plugin := range config.Plugins {
-		pluginEntities := plugin.Scan()
-		if pluginEntities == nil {
-			//some fatal error occurred - it was already reported, so just exit
-			os.Exit(255)
+	entities, hadScanErrors := plugins.ScanAll(config.Plugins, config.ScanJobs)
+	if entities == nil {
+		//some fatal error occurred - it was already reported, so just exit
+		if plugins.Interrupted() {
+			exit(exitInterrupted)
 		}
-		entities = append(entities, pluginEntities...)
-	}
-
-	//build a lookup hash for all known entities (for argument parsing)
-	isEntityID := make(map[string]bool, len(entities))
-	for _, entity := range entities {
-		isEntityID[entity.EntityID()] = true
+		exit(exitFatal)
 	}
 
 	//parse command line
 	options := make(map[int]bool)
-	isEntityIDSelected := make(map[string]bool, len(entities))
+	var selection entitySelection
+	valueOpts := map[string]*[]string{
+		"--plugin":  &selection.PluginIDs,
+		"--exclude": &selection.Excludes,
+	}
+	for opt, values := range commandValueOpts {
+		valueOpts[opt] = values
+	}
 	hasUnrecognizedArgs := false
 
 	args := os.Args[2:]
-	for _, arg := range args {
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
 		//either it's a known option for this subcommand...
 		if value, ok := knownOpts[arg]; ok {
 			options[value] = true
 			continue
 		}
-		//...or it must be an entity ID
-		if isEntityID[arg] {
-			isEntityIDSelected[arg] = true
+		//...or an option with a value (as "--option=value" or "--option value")...
+		if fields := strings.SplitN(arg, "=", 2); len(fields) == 2 && valueOpts[fields[0]] != nil {
+			*valueOpts[fields[0]] = append(*valueOpts[fields[0]], fields[1])
+			continue
+		}
+		if values, ok := valueOpts[arg]; ok {
+			if idx+1 == len(args) {
+				fmt.Fprintf(os.Stderr, "Missing value for argument: %s\n", arg)
+				hasUnrecognizedArgs = true
+			} else {
+				idx++
+				*values = append(*values, args[idx])
+			}
+			continue
+		}
+		//...or it must be an entity ID or pattern that selects some entities
+		if selectsAnyEntity(arg, entities) {
+			selection.Patterns = append(selection.Patterns, arg)
 		} else {
 			fmt.Fprintf(os.Stderr, "Unrecognized argument: %s\n", arg)
 			hasUnrecognizedArgs = true
 		}
 	}
+	for _, pluginID := range selection.PluginIDs {
+		if !isPluginID(pluginID, config.Plugins) {
+			fmt.Fprintf(os.Stderr, "Unknown plugin: %s\n", pluginID)
+			hasUnrecognizedArgs = true
+		}
+	}
+	if needsSelection && len(selection.Patterns) == 0 && !hasUnrecognizedArgs {
+		fmt.Fprintf(os.Stderr, "Missing argument: entity selection\n")
+		hasUnrecognizedArgs = true
+	}
 	if hasUnrecognizedArgs {
-		os.Exit(255)
+		exit(exitFatal)
 	}
 
-	//if entities have been selected, limit the entities slice to these
-	if len(isEntityIDSelected) > 0 {
-		selectedEntities := make([]*plugins.Entity, 0, len(entities))
-		for _, entity := range entities {
-			if isEntityIDSelected[entity.EntityID()] {
-				selectedEntities = append(selectedEntities, entity)
-			}
+	//limit the entities slice to the selected entities
+	entities = selection.filter(entities)
+
+	//entities must be provisioned after the entities that they depend on
+	if needsDependencyOrder {
+		var err error
+		entities, err = plugins.SortByDependencies(entities)
+		if err != nil {
+			r := plugins.Report{Action: "Errors occurred during", Target: "dependency resolution"}
+			r.AddError(err.Error())
+			r.Print()
+			exit(exitFatal)
 		}
-		entities = selectedEntities
 	}
 
 	//execute command
-	command(entities, options)
+	exitCode := command(entities, options)
+	if exitCode == exitSuccess && hadScanErrors {
+		exitCode = exitFailed
+	}
+	if plugins.Interrupted() {
+		r := plugins.Report{Action: "Interrupted", Target: "by signal"}
+		r.AddError("skipped all remaining entities")
+		r.Print()
+		exitCode = exitInterrupted
+	}
 
-	//cleanup
+	exit(exitCode)
+}
+
+//exit releases the lock (if held) and cleans up the runtime cache before
+//exiting with the given exit code.
+func exit(exitCode int) {
+	plugins.ReleaseLock()
 	plugins.CleanupRuntimeCache()
+	os.Exit(exitCode)
 }
 
 func commandHelp() {
 	program := os.Args[0]
 	fmt.Printf("Usage: %s <operation> [...]\nOperations:\n", program)
-	fmt.Printf("    %s apply [-f|--force] [entity ...]\n", program)
-	fmt.Printf("    %s diff [entity ...]\n", program)
-	fmt.Printf("    %s scan [-s|--short] [entity ...]\n", program)
+	fmt.Printf("    %s apply [-f|--force] [-n|--dry-run] [--wait] [--format=json] [selection ...]\n", program)
+	fmt.Printf("    %s check [-f|--force] [--format=json] [selection ...]\n", program)
+	fmt.Printf("    %s diff [--pending] [-U|--unified N] [--word-diff|--side-by-side] [--format=json] [selection ...]\n", program)
+	fmt.Printf("    %s scan [-s|--short] [--format=json] [selection ...]\n", program)
+	fmt.Printf("    %s rollback [-f|--force] [-l|--list] [--wait] [--to VERSION] selection ...\n", program)
+	fmt.Printf("    %s history [--format=json] [entity ...]\n", program)
+	fmt.Printf("\nEntities can be selected by their IDs, by shell globs (e.g. \"user:*\") or\n")
+	fmt.Printf("by their parent directory (e.g. \"/etc/nginx\"), and further restricted\n")
+	fmt.Printf("with \"--plugin ID\" and \"--exclude PATTERN\".\n")
 	fmt.Printf("\nSee `man 8 holo` for details.\n")
 }
 
-func commandApply(entities []*plugins.Entity, options map[int]bool) {
-	withForce := options[optionApplyForce]
+func commandApply(entities []*plugins.Entity, options map[int]bool, config *plugins.Configuration) int {
+	results := applyEntities(entities, config, options[optionApplyForce], options[optionApplyDryRun], options[optionFormatJSON])
+	switch {
+	case results[plugins.ApplyFailed] > 0:
+		return exitFailed
+	case results[plugins.ApplyNeedsForce] > 0:
+		return exitNeedsForce
+	default:
+		return exitSuccess
+	}
+}
+
+func commandCheck(entities []*plugins.Entity, options map[int]bool) int {
+	results := applyEntities(entities, nil, options[optionApplyForce], true, options[optionFormatJSON])
+	switch {
+	case results[plugins.ApplyFailed] > 0:
+		return exitFailed
+	case results[plugins.ApplyNeedsForce] > 0:
+		return exitNeedsForce
+	case results[plugins.ApplyChanged] > 0:
+		return exitChangesPending
+	default:
+		return exitSuccess
+	}
+}
+
+//applyEntities runs Entity.Apply() on all given entities and counts how often
+//each result occurred. Entities whose required entities could not be
+//provisioned are skipped, and count as failed. Unless dryRun is set, the
+//outcome is recorded in the history, and the hooks from the given
+//configuration are run (a failed post-apply hook counts as a failed entity).
+func applyEntities(entities []*plugins.Entity, config *plugins.Configuration, withForce, dryRun, withJSON bool) map[plugins.ApplyResult]int {
+	var history *plugins.HistoryRun
+	var preApplyHooks, postApplyHooks []plugins.Hook
+	var hookTimeout time.Duration
+	if !dryRun {
+		history = plugins.NewHistoryRun(os.Args[1:])
+		if config != nil {
+			preApplyHooks, postApplyHooks = config.PreApplyHooks, config.PostApplyHooks
+			hookTimeout = config.HookTimeout
+		}
+	}
+
+	results := make(map[plugins.ApplyResult]int)
+
+	//if a global pre-apply hook fails, do not touch any entity
+	if len(entities) > 0 && runPreApplyHooks(preApplyHooks, nil, hookTimeout, withJSON) != "" {
+		results[plugins.ApplyFailed]++
+		return results
+	}
+
+	resultByID := make(map[string]plugins.ApplyResult, len(entities))
+	var changedIDs []string
 	for _, entity := range entities {
-		entity.Apply(withForce)
+		if plugins.Interrupted() {
+			break
+		}
+		var record *plugins.Record
+		skipReason := unsatisfiedRequirement(entity, resultByID)
+		if skipReason == "" {
+			if failedHook := runPreApplyHooks(preApplyHooks, entity, hookTimeout, withJSON); failedHook != "" {
+				skipReason = "skipping entity: pre-apply hook failed: " + failedHook
+			}
+		}
+		switch {
+		case skipReason != "" && withJSON:
+			record = entity.SkipRecord(skipReason)
+			record.Print()
+		case skipReason != "":
+			record = entity.Skip(skipReason)
+		case withJSON:
+			record = entity.ApplyRecord(withForce, dryRun)
+			record.Print()
+		default:
+			record = entity.Apply(withForce, dryRun)
+		}
+		results[*record.Result]++
+		resultByID[entity.EntityID()] = *record.Result
+		if *record.Result == plugins.ApplyChanged {
+			changedIDs = append(changedIDs, entity.EntityID())
+		}
+		if history != nil {
+			history.Add(record)
+		}
+	}
+
+	if !plugins.Interrupted() && !runPostApplyHooks(postApplyHooks, changedIDs, hookTimeout, withJSON) {
+		results[plugins.ApplyFailed]++
 	}
+
+	if history != nil {
+		err := history.Save(config.HistorySize)
+		if err != nil {
+			r := plugins.Report{Action: "Errors occurred during", Target: "history recording"}
+			r.AddError(err.Error())
+			r.Print()
+		}
+	}
+	return results
 }
 
-func commandScan(entities []*plugins.Entity, options map[int]bool) {
+func commandRollback(entities []*plugins.Entity, options map[int]bool, versions []string, config *plugins.Configuration) int {
+	//a specific version can only be selected for a single entity
+	version := ""
+	if len(versions) > 0 {
+		if len(versions) > 1 || len(entities) > 1 {
+			fmt.Fprintf(os.Stderr, "--to can only be given once, and only for a single entity\n")
+			return exitFatal
+		}
+		version = versions[0]
+	}
+
+	if options[optionRollbackList] {
+		exitCode := exitSuccess
+		for _, entity := range entities {
+			r := plugins.Report{Action: "Versions of", Target: entity.EntityID()}
+			versions, err := entity.Versions()
+			if err != nil {
+				r.AddError(err.Error())
+				exitCode = exitFailed
+			} else if len(versions) == 0 {
+				r.AddWarning("no previous versions available")
+			}
+			for _, v := range versions {
+				r.AddLine(v.ID, v.Description)
+			}
+			r.Print()
+		}
+		return exitCode
+	}
+
+	history := plugins.NewHistoryRun(os.Args[1:])
+	results := make(map[plugins.ApplyResult]int)
+	for _, entity := range entities {
+		if plugins.Interrupted() {
+			break
+		}
+		record := entity.Rollback(version, options[optionApplyForce])
+		results[*record.Result]++
+		history.Add(record)
+	}
+	err := history.Save(config.HistorySize)
+	if err != nil {
+		r := plugins.Report{Action: "Errors occurred during", Target: "history recording"}
+		r.AddError(err.Error())
+		r.Print()
+	}
+
+	switch {
+	case results[plugins.ApplyFailed] > 0:
+		return exitFailed
+	case results[plugins.ApplyNeedsForce] > 0:
+		return exitNeedsForce
+	default:
+		return exitSuccess
+	}
+}
+
+//unsatisfiedRequirement checks if any of the entities required by the given
+//entity could not be provisioned, and returns a message explaining why the
+//entity needs to be skipped (or an empty string if it does not).
+func unsatisfiedRequirement(entity *plugins.Entity, resultByID map[string]plugins.ApplyResult) string {
+	for _, id := range entity.Requires() {
+		result, exists := resultByID[id]
+		if exists && (result == plugins.ApplyFailed || result == plugins.ApplyNeedsForce) {
+			return fmt.Sprintf("skipping entity: required entity %s was not provisioned", id)
+		}
+	}
+	return ""
+}
+
+func commandScan(entities []*plugins.Entity, options map[int]bool) int {
 	isShort := options[optionScanShort]
 	for _, entity := range entities {
-		if isShort {
+		if options[optionFormatJSON] {
+			entity.Record().Print()
+		} else if isShort {
 			fmt.Println(entity.EntityID())
 		} else {
 			entity.Report().Print()
 		}
 	}
+	return exitSuccess
 }
 
-func commandDiff(entities []*plugins.Entity, options map[int]bool) {
+func commandDiff(entities []*plugins.Entity, options map[int]bool, contexts []string) int {
+	diffOpts := plugins.DiffOptions{Context: -1, Pending: options[optionDiffPending]}
+	if len(contexts) > 0 {
+		context, err := strconv.Atoi(contexts[len(contexts)-1])
+		if err != nil || context < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid value for --unified: %s\n", contexts[len(contexts)-1])
+			return exitFatal
+		}
+		diffOpts.Context = context
+	}
+	switch {
+	case options[optionDiffWords] && options[optionDiffSideBySide]:
+		fmt.Fprintf(os.Stderr, "--word-diff and --side-by-side cannot be given at the same time\n")
+		return exitFatal
+	case options[optionDiffWords]:
+		diffOpts.Format = "words"
+	case options[optionDiffSideBySide]:
+		diffOpts.Format = "side-by-side"
+	}
+
+	exitCode := exitSuccess
 	for _, entity := range entities {
-		output, err := entity.RenderDiff()
+		if plugins.Interrupted() {
+			break
+		}
+		if options[optionFormatJSON] {
+			record := entity.DiffRecord(diffOpts)
+			record.Print()
+			if len(record.Errors) > 0 {
+				exitCode = exitFailed
+			}
+			continue
+		}
+		output, err := entity.RenderDiff(diffOpts)
 		if err != nil {
-			report := plugins.Report{Action: "diff", Target: entity.EntityID()}
-			report.AddError(err.Error())
-			report.Print()
+			if !plugins.IsReportedFailure(err) {
+				report := plugins.Report{Action: "diff", Target: entity.EntityID()}
+				report.AddError(err.Error())
+				report.Print()
+			}
+			exitCode = exitFailed
 		}
 		os.Stdout.Write(output)
 	}
+	return exitCode
 }
@@ -21,10 +21,15 @@
 package plugins
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var rootDirectory string
@@ -45,49 +50,58 @@ func RootDirectory() string {
 //Configuration contains the parsed contents of /etc/holorc.
 type Configuration struct {
 	Plugins []*Plugin
+	//ScanJobs is the maximum number of plugins that may run their scan
+	//operation concurrently.
+	ScanJobs int
+	//PreApplyHooks and PostApplyHooks are run by `holo apply` before and
+	//after provisioning entities.
+	PreApplyHooks  []Hook
+	PostApplyHooks []Hook
+	//HistorySize is the maximum number of runs that are kept in the history.
+	HistorySize int
+	//HookTimeout is the maximum runtime of a single hook (0 means no limit).
+	HookTimeout time.Duration
+}
+
+//Hook is a shell command that is run by `holo apply` (see holorc(5)).
+type Hook struct {
+	//Pattern selects the entities that the hook applies to. If empty, the
+	//hook applies to all entities.
+	Pattern string
+	Command string
+}
+
+//configParser holds the state of ReadConfiguration() while it reads holorc and
+//the files included by it.
+type configParser struct {
+	result   Configuration
+	plugins  map[string]*Plugin
+	disabled map[string]bool
+	//the files that are currently being read (to detect include loops)
+	reading map[string]bool
 }
 
 //ReadConfiguration reads the configuration file /etc/holorc.
 func ReadConfiguration() *Configuration {
-	path := filepath.Join(RootDirectory(), "etc/holorc")
-
-	contents, err := ioutil.ReadFile(path)
-	if err != nil {
-		r := Report{Action: "read", Target: path}
-		r.AddError(err.Error())
-		r.Print()
+	parser := configParser{
+		result:   Configuration{ScanJobs: runtime.NumCPU(), HistorySize: 100},
+		plugins:  make(map[string]*Plugin),
+		disabled: make(map[string]bool),
+		reading:  make(map[string]bool),
+	}
+	if !parser.readFile(filepath.Join(RootDirectory(), "etc/holorc")) {
 		return nil
 	}
 
-	var result Configuration
-	lines := strings.SplitN(strings.TrimSpace(string(contents)), "\n", -1)
-	for _, line := range lines {
-		//ignore comments and empty lines
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "#") || line == "" {
-			continue
-		}
-
-		//collect plugin IDs
-		if strings.HasPrefix(line, "plugin ") {
-			pluginID := strings.TrimSpace(strings.TrimPrefix(line, "plugin"))
-			if strings.Contains(pluginID, "=") {
-				fields := strings.SplitN(pluginID, "=", 2)
-				result.Plugins = append(result.Plugins,
-					NewPluginWithExecutablePath(fields[0], fields[1]),
-				)
-			} else {
-				result.Plugins = append(result.Plugins, NewPlugin(pluginID))
-			}
-			continue
-		} else {
-			//unknown line
-			r := Report{Action: "read", Target: path}
-			r.AddError("unknown command: %s", line)
-			r.Print()
-			return nil
+	//remove disabled plugins
+	result := parser.result
+	var enabledPlugins []*Plugin
+	for _, plugin := range result.Plugins {
+		if !parser.disabled[plugin.ID()] {
+			enabledPlugins = append(enabledPlugins, plugin)
 		}
 	}
+	result.Plugins = enabledPlugins
 
 	//check existence of resource directories
 	errorReport := Report{Action: "Errors occurred during", Target: "plugin discovery"}
@@ -127,3 +141,223 @@ func ReadConfiguration() *Configuration {
 
 	return &result
 }
+
+//readFile reads a configuration file. Errors are reported immediately, in
+//which case false is returned.
+func (c *configParser) readFile(path string) bool {
+	if c.reading[path] {
+		r := Report{Action: "read", Target: path}
+		r.AddError("file includes itself (possibly indirectly)")
+		r.Print()
+		return false
+	}
+	c.reading[path] = true
+	defer delete(c.reading, path)
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		r := Report{Action: "read", Target: path}
+		r.AddError(err.Error())
+		r.Print()
+		return false
+	}
+
+	lines := strings.Split(string(contents), "\n")
+	for idx, line := range lines {
+		//ignore comments and empty lines
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+
+		//the include directive reports its own errors
+		if strings.HasPrefix(line, "include ") {
+			pattern := strings.TrimSpace(strings.TrimPrefix(line, "include"))
+			if !c.include(path, idx+1, pattern) {
+				return false
+			}
+			continue
+		}
+
+		err := c.parseLine(line)
+		if err != nil {
+			r := Report{Action: "read", Target: path}
+			r.AddError("line %d: %s", idx+1, err.Error())
+			r.Print()
+			return false
+		}
+	}
+
+	return true
+}
+
+//include handles the "include" directive in line `lineNo` of the file at
+//`path`.
+func (c *configParser) include(path string, lineNo int, pattern string) bool {
+	//absolute paths are relative to the root directory, relative paths are
+	//relative to the including file
+	if filepath.IsAbs(pattern) {
+		pattern = filepath.Join(RootDirectory(), pattern)
+	} else {
+		pattern = filepath.Join(filepath.Dir(path), pattern)
+	}
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		r := Report{Action: "read", Target: path}
+		r.AddError("line %d: %s", lineNo, err.Error())
+		r.Print()
+		return false
+	}
+	for _, includedPath := range paths {
+		if !c.readFile(includedPath) {
+			return false
+		}
+	}
+	return true
+}
+
+//parseLine parses a configuration line other than a comment, empty line or
+//include directive.
+func (c *configParser) parseLine(line string) error {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "plugin":
+		//the executable path may contain spaces
+		fields = splitFields(line, 2)
+		if len(fields) != 2 || strings.ContainsAny(strings.SplitN(fields[1], "=", 2)[0], " \t") {
+			return errors.New("expected \"plugin ID\" or \"plugin ID=PATH\"")
+		}
+		var plugin *Plugin
+		if strings.Contains(fields[1], "=") {
+			args := strings.SplitN(fields[1], "=", 2)
+			plugin = NewPluginWithExecutablePath(args[0], args[1])
+		} else {
+			plugin = NewPlugin(fields[1])
+		}
+		if c.plugins[plugin.ID()] != nil {
+			return fmt.Errorf("plugin %s was already declared", plugin.ID())
+		}
+		c.plugins[plugin.ID()] = plugin
+		c.result.Plugins = append(c.result.Plugins, plugin)
+
+	case "enable", "disable":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected \"%s ID\"", fields[0])
+		}
+		if c.plugins[fields[1]] == nil {
+			return fmt.Errorf("unknown plugin: %s", fields[1])
+		}
+		c.disabled[fields[1]] = fields[0] == "disable"
+
+	case "env":
+		//the value may contain spaces
+		fields = splitFields(line, 3)
+		if len(fields) != 3 || !strings.Contains(fields[2], "=") {
+			return errors.New("expected \"env ID KEY=VALUE\"")
+		}
+		plugin := c.plugins[fields[1]]
+		if plugin == nil {
+			return fmt.Errorf("unknown plugin: %s", fields[1])
+		}
+		key := strings.SplitN(fields[2], "=", 2)[0]
+		if isReservedEnvironmentVariable(key) {
+			return fmt.Errorf("environment variable %s is set by Holo and cannot be overridden", key)
+		}
+		plugin.env = append(plugin.env, fields[2])
+
+	case "timeout":
+		if len(fields) == 3 {
+			//timeout for all operations
+			fields = []string{fields[0], fields[1], "", fields[2]}
+		}
+		if len(fields) != 4 {
+			return errors.New("expected \"timeout ID [OPERATION] DURATION\"")
+		}
+		plugin := c.plugins[fields[1]]
+		if plugin == nil {
+			return fmt.Errorf("unknown plugin: %s", fields[1])
+		}
+		switch fields[2] {
+		case "", "scan", "apply", "diff":
+		default:
+			return fmt.Errorf("unknown operation: %s (expected \"scan\", \"apply\" or \"diff\")", fields[2])
+		}
+		timeout, err := time.ParseDuration(fields[3])
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout: %s (expected a positive duration like \"30s\" or \"5m\")", fields[3])
+		}
+		plugin.timeouts[fields[2]] = timeout
+
+	case "scan-jobs":
+		if len(fields) != 2 {
+			return errors.New("expected \"scan-jobs COUNT\"")
+		}
+		jobs, err := strconv.Atoi(fields[1])
+		if err != nil || jobs < 1 {
+			return fmt.Errorf("invalid value for scan-jobs: %s (expected a positive integer)", fields[1])
+		}
+		c.result.ScanJobs = jobs
+
+	case "history-size":
+		if len(fields) != 2 {
+			return errors.New("expected \"history-size COUNT\"")
+		}
+		size, err := strconv.Atoi(fields[1])
+		if err != nil || size < 1 {
+			return fmt.Errorf("invalid value for history-size: %s (expected a positive integer)", fields[1])
+		}
+		c.result.HistorySize = size
+
+	case "hook-timeout":
+		if len(fields) != 2 {
+			return errors.New("expected \"hook-timeout DURATION\"")
+		}
+		timeout, err := time.ParseDuration(fields[1])
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout: %s (expected a positive duration like \"30s\" or \"5m\")", fields[1])
+		}
+		c.result.HookTimeout = timeout
+
+	case "pre-apply", "post-apply":
+		//the command is separated from the optional pattern by "=", and may
+		//contain spaces (and further "=")
+		args := strings.SplitN(strings.TrimPrefix(line, fields[0]), "=", 2)
+		hook := Hook{Pattern: strings.TrimSpace(args[0])}
+		if len(args) == 2 {
+			hook.Command = strings.TrimSpace(args[1])
+		}
+		if hook.Command == "" {
+			return fmt.Errorf("expected \"%s [PATTERN] = COMMAND\"", fields[0])
+		}
+		if fields[0] == "pre-apply" {
+			c.result.PreApplyHooks = append(c.result.PreApplyHooks, hook)
+		} else {
+			c.result.PostApplyHooks = append(c.result.PostApplyHooks, hook)
+		}
+
+	default:
+		return fmt.Errorf("unknown command: %s", line)
+	}
+
+	return nil
+}
+
+//splitFields is like strings.Fields, but returns at most n fields. The last
+//field contains the remainder of the line.
+func splitFields(line string, n int) []string {
+	var result []string
+	line = strings.TrimSpace(line)
+	for line != "" && len(result) < n-1 {
+		idx := strings.IndexAny(line, " \t")
+		if idx < 0 {
+			break
+		}
+		result = append(result, line[:idx])
+		line = strings.TrimSpace(line[idx:])
+	}
+	if line != "" {
+		result = append(result, line)
+	}
+	return result
+}
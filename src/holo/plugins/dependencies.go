@@ -0,0 +1,125 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+)
+
+//SortByDependencies sorts the given entities such that every entity comes
+//after all entities that it requires or is ordered after (as declared by the
+//REQUIRES and AFTER keys in the scan report). Apart from that, the given order
+//is preserved. Dependencies on entities that are not in the given slice are
+//ignored. If the dependencies are cyclic, an error naming the entities in the
+//cycle is returned.
+func SortByDependencies(entities []*Entity) ([]*Entity, error) {
+	indexOf := make(map[string]int, len(entities))
+	for idx, entity := range entities {
+		indexOf[entity.id] = idx
+	}
+
+	//build the dependency graph: unresolved[idx] counts the dependencies of
+	//entities[idx] that have not been placed yet; dependents[idx] lists the
+	//entities that depend on entities[idx]
+	unresolved := make([]int, len(entities))
+	dependents := make([][]int, len(entities))
+	for idx, entity := range entities {
+		for _, id := range entity.dependencies() {
+			depIdx, exists := indexOf[id]
+			if exists {
+				unresolved[idx]++
+				dependents[depIdx] = append(dependents[depIdx], idx)
+			}
+		}
+	}
+
+	//Kahn's algorithm; among the entities that are ready, always take the one
+	//that comes first in the original order
+	ready := &indexHeap{}
+	for idx := range entities {
+		if unresolved[idx] == 0 {
+			heap.Push(ready, idx)
+		}
+	}
+	result := make([]*Entity, 0, len(entities))
+	for ready.Len() > 0 {
+		idx := heap.Pop(ready).(int)
+		result = append(result, entities[idx])
+		for _, depIdx := range dependents[idx] {
+			unresolved[depIdx]--
+			if unresolved[depIdx] == 0 {
+				heap.Push(ready, depIdx)
+			}
+		}
+	}
+
+	if len(result) < len(entities) {
+		return nil, findDependencyCycle(entities, indexOf, unresolved)
+	}
+	return result, nil
+}
+
+//findDependencyCycle is called when SortByDependencies() could not place all
+//entities. Every entity that was not placed has at least one dependency that
+//was not placed either, so following these dependencies must eventually lead
+//into a cycle.
+func findDependencyCycle(entities []*Entity, indexOf map[string]int, unresolved []int) error {
+	//start at the first entity that was not placed
+	current := 0
+	for unresolved[current] == 0 {
+		current++
+	}
+
+	visitedAt := make(map[int]int)
+	var path []string
+	for {
+		if start, visited := visitedAt[current]; visited {
+			cycle := append(path[start:], entities[current].id)
+			return fmt.Errorf("dependency cycle between entities: %s", strings.Join(cycle, " -> "))
+		}
+		visitedAt[current] = len(path)
+		path = append(path, entities[current].id)
+
+		for _, id := range entities[current].dependencies() {
+			depIdx, exists := indexOf[id]
+			if exists && unresolved[depIdx] > 0 {
+				current = depIdx
+				break
+			}
+		}
+	}
+}
+
+//indexHeap implements heap.Interface for a min-heap of ints.
+type indexHeap []int
+
+func (h indexHeap) Len() int            { return len(h) }
+func (h indexHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h indexHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *indexHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *indexHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
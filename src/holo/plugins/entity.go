@@ -23,8 +23,13 @@ package plugins
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 //InfoLine represents a line in the information section of an Entity.
@@ -33,6 +38,52 @@ type InfoLine struct {
 	value     string
 }
 
+//ApplyResult describes the outcome of Entity.Apply().
+type ApplyResult int
+
+const (
+	//ApplyChanged means that the entity was provisioned (or, during a dry
+	//run, that it would be provisioned).
+	ApplyChanged ApplyResult = iota
+	//ApplyUnchanged means that the entity was already in the desired state.
+	ApplyUnchanged
+	//ApplyNeedsForce means that the plugin refused to provision the entity
+	//because it was changed by the user or by another program.
+	ApplyNeedsForce
+	//ApplyFailed means that an error occurred during provisioning.
+	ApplyFailed
+)
+
+//String returns the representation of this ApplyResult in JSON output.
+func (r ApplyResult) String() string {
+	switch r {
+	case ApplyChanged:
+		return "changed"
+	case ApplyUnchanged:
+		return "unchanged"
+	case ApplyNeedsForce:
+		return "needs-force"
+	default:
+		return "failed"
+	}
+}
+
+//MarshalText implements the encoding.TextMarshaler interface.
+func (r ApplyResult) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+//UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (r *ApplyResult) UnmarshalText(text []byte) error {
+	for _, result := range []ApplyResult{ApplyChanged, ApplyUnchanged, ApplyNeedsForce, ApplyFailed} {
+		if string(text) == result.String() {
+			*r = result
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid apply result: %s", string(text))
+}
+
 //Entity represents an entity known to some Holo plugin.
 type Entity struct {
 	plugin       *Plugin
@@ -40,11 +91,26 @@ type Entity struct {
 	actionVerb   string
 	actionReason string
 	infoLines    []InfoLine
+	requires     []string
+	after        []string
 }
 
 //EntityID returns a string that uniquely identifies the entity.
 func (e *Entity) EntityID() string { return e.id }
 
+//PluginID returns the ID of the plugin that provides this entity.
+func (e *Entity) PluginID() string { return e.plugin.ID() }
+
+//Requires returns the IDs of the entities that must be provisioned
+//successfully before this entity can be provisioned.
+func (e *Entity) Requires() []string { return e.requires }
+
+//dependencies returns the IDs of all entities that need to be provisioned
+//before this entity.
+func (e *Entity) dependencies() []string {
+	return append(append([]string(nil), e.requires...), e.after...)
+}
+
 //Report generates a Report describing this Entity.
 func (e *Entity) Report() *Report {
 	r := Report{Target: e.id, State: e.actionReason}
@@ -54,93 +120,335 @@ func (e *Entity) Report() *Report {
 	return &r
 }
 
-//Apply performs the complete application algorithm for the given Entity.
-func (e *Entity) Apply(withForce bool) {
-	err := e.doApply(withForce)
-	if err != nil {
+//Apply performs the complete application algorithm for the given Entity. If
+//dryRun is true, the plugin is only asked to report what it would do. The
+//plugin's output is printed while it runs. The returned Record describes the
+//outcome, and is not printed.
+func (e *Entity) Apply(withForce, dryRun bool) *Record {
+	return e.streamOperation(e.actionVerb, func(stdout, stderr io.Writer) (ApplyResult, map[string]string, error) {
+		return e.doApply(withForce, dryRun, stdout, stderr)
+	})
+}
+
+//streamOperation implements Apply() and Rollback(). The given operation
+//receives the writers for the plugin's output, which is printed below a report
+//header with the given action verb.
+func (e *Entity) streamOperation(action string, operation func(stdout, stderr io.Writer) (ApplyResult, map[string]string, error)) *Record {
+	//plugin output is passed through as soon as it arrives, but the report
+	//header is printed before the first output
+	output := &applyOutput{entity: e, action: action}
+	stdout, stderr := output.Writer(os.Stdout), output.Writer(os.Stderr)
+	if isSameFile(os.Stdout, os.Stderr) {
+		//when both go to the same file (e.g. a terminal), also send them
+		//through the same pipe to preserve the order of stdout and stderr
+		stderr = stdout
+	}
+	result, journal, err := operation(stdout, stderr)
+
+	//if there was no output, only print the report if the plugin provisioned
+	//the entity (as signaled by the absence of the "not changed\n" command)
+	if !output.headerPrinted && result != ApplyUnchanged {
+		e.printReportWithAction(action)
+	}
+
+	//if output was written, insert an empty line to preserve our own paragraph layout
+	switch output.lastByte {
+	case 0:
+		//no output
+	case '\n':
+		os.Stdout.Write([]byte("\n"))
+	default:
+		os.Stdout.Write([]byte("\n\n"))
+	}
+
+	//the plugin has already explained why it needs --force or why it failed,
+	//so the non-zero exit code is not worth mentioning
+	if err != nil && result != ApplyNeedsForce && !isReportedFailure(err, output.stdoutCopy.Bytes(), output.stderrCopy.Bytes()) {
 		fmt.Printf("\x1b[31m\x1b[1m!!\x1b[0m %s\n\n", err.Error())
 	}
+	r := e.applyRecord(result, journal, err, output.stdoutCopy.Bytes(), output.stderrCopy.Bytes())
+	r.ActionVerb = action
+	return r
+}
+
+//ApplyRecord is like Apply, but does not print anything. The plugin's output
+//is included in the returned Record instead.
+func (e *Entity) ApplyRecord(withForce, dryRun bool) *Record {
+	var stdout, stderr bytes.Buffer
+	result, journal, err := e.doApply(withForce, dryRun, &stdout, &stderr)
+	return e.applyRecord(result, journal, err, stdout.Bytes(), stderr.Bytes())
+}
+
+func (e *Entity) applyRecord(result ApplyResult, journal map[string]string, err error, stdout, stderr []byte) *Record {
+	r := e.Record()
+	changed := result == ApplyChanged
+	r.Changed = &changed
+	r.Result = &result
+	if len(journal) > 0 {
+		r.Journal = journal
+	}
+	r.AddOutput(stdout)
+	r.AddOutput(stderr)
+	if err != nil && result != ApplyNeedsForce && !isReportedFailure(err, stdout, stderr) {
+		r.AddError(err.Error())
+	}
+	return r
+}
+
+//isReportedFailure returns true if the given error only says that a plugin
+//exited with non-zero status, and the plugin has already explained why in its
+//output (in a line starting with "!!"). Such errors are counted, but not shown
+//to the user again.
+func isReportedFailure(err error, outputs ...[]byte) bool {
+	if _, ok := err.(*exec.ExitError); !ok {
+		return false
+	}
+	for _, output := range outputs {
+		for _, line := range strings.Split(string(output), "\n") {
+			if strings.HasPrefix(line, "!!") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//IsReportedFailure returns true if the given error was returned by
+//Entity.RenderDiff() for a plugin that has already explained its failure.
+func IsReportedFailure(err error) bool {
+	_, ok := err.(reportedFailure)
+	return ok
 }
 
-func (e *Entity) printApplyReport() {
+type reportedFailure struct {
+	error
+}
+
+//Skip reports that the given Entity will not be provisioned for the given
+//reason, without invoking the plugin. Like Apply, it returns a Record
+//describing the outcome.
+func (e *Entity) Skip(reason string) *Record {
+	e.printReportWithAction(e.actionVerb)
+	fmt.Printf("\x1b[31m\x1b[1m!!\x1b[0m %s\n\n", reason)
+	return e.SkipRecord(reason)
+}
+
+//SkipRecord is like Skip, but does not print anything.
+func (e *Entity) SkipRecord(reason string) *Record {
+	r := e.Record()
+	changed := false
+	result := ApplyFailed
+	r.Changed = &changed
+	r.Result = &result
+	r.AddError(reason)
+	return r
+}
+
+func (e *Entity) printReportWithAction(action string) {
 	r := e.Report()
-	r.Action = e.actionVerb
+	r.Action = action
 	r.Print()
 }
 
-func (e *Entity) doApply(withForce bool) error {
+func (e *Entity) doApply(withForce, dryRun bool, stdout, stderr io.Writer) (result ApplyResult, journal map[string]string, err error) {
 	command := "apply"
+	if dryRun {
+		command = "plan"
+	}
 	if withForce {
-		command = "force-apply"
+		command = "force-" + command
+	}
+	if dryRun && !e.plugin.HasCapability("plan") {
+		fmt.Fprintf(stderr, ">> plugin %s does not support dry runs, so its actions cannot be predicted\n", e.plugin.ID())
+		return ApplyChanged, nil, nil
 	}
 
+	return e.runWithCommandChannel([]string{command, e.id}, stdout, stderr)
+}
+
+//runWithCommandChannel runs the plugin with the given arguments for an
+//operation that changes the entity, and evaluates the messages that the
+//plugin writes into file descriptor 3.
+func (e *Entity) runWithCommandChannel(args []string, stdout, stderr io.Writer) (result ApplyResult, journal map[string]string, err error) {
 	//the command channel (file descriptor 3 on the side of the plugin) can
 	//only be set up with an *os.File instance, so use a pipe that the plugin
 	//writes into and that we read from
 	cmdReader, cmdWriterForPlugin, err := os.Pipe()
 	if err != nil {
-		e.printApplyReport()
-		return err
+		return ApplyFailed, nil, err
 	}
 
-	//TODO: This implementation is stupid and buffers all the output before
-	//deciding what to print and how. Technically we could just patch stdout
-	//and stderr through directly, but there is a caveat: We always want the
-	//scan report in front of all output.
-	var output bytes.Buffer
-	cmd := e.plugin.Command([]string{command, e.id}, &output, &output, cmdWriterForPlugin)
-	err = cmd.Start() //cannot use Run() since we need to read from the pipe before the plugin exits
+	cmd := e.plugin.Command(args, stdout, stderr, cmdWriterForPlugin)
+	process, err := e.plugin.start(cmd) //cannot use run() since we need to read from the pipe before the plugin exits
 	if err != nil {
-		e.printApplyReport()
-		return err
+		cmdReader.Close()
+		cmdWriterForPlugin.Close()
+		return ApplyFailed, nil, err
 	}
 
 	cmdWriterForPlugin.Close() //or next line will block (see Plugin.Command docs)
-	cmdBytes, err := ioutil.ReadAll(cmdReader)
-	if err != nil {
-		e.printApplyReport()
-		return err
+	cmdBytes, readErr := ioutil.ReadAll(cmdReader)
+	closeErr := cmdReader.Close()
+
+	//always reap the plugin, even if the command channel failed
+	err = process.wait()
+	if readErr != nil {
+		return ApplyFailed, nil, readErr
 	}
-	err = cmdReader.Close()
-	if err != nil {
-		e.printApplyReport()
-		return err
-	}
-	err = cmd.Wait()
-
-	//only print report if there was output, or if the plugin provisioned the
-	//entity (as signaled by the absence of the "not changed\n" command")
-	showReport := true
-	if output.Len() == 0 && err == nil {
-		cmdLines := bytes.Split(cmdBytes, []byte("\n"))
-		for _, line := range cmdLines {
-			if string(line) == "not changed" {
-				showReport = false
+	if closeErr != nil {
+		return ApplyFailed, nil, closeErr
+	}
+
+	//the plugin signals that it did not provision the entity by writing the
+	//"not changed\n" command, or that it refused to provision the entity by
+	//writing the "requires --force\n" command; additional information for the
+	//history is given in "journal: key=value\n" commands
+	result = ApplyChanged
+	journal = make(map[string]string)
+	cmdLines := strings.Split(string(cmdBytes), "\n")
+	for _, line := range cmdLines {
+		switch {
+		case line == "not changed":
+			if result == ApplyChanged {
+				result = ApplyUnchanged
 			}
+		case line == "requires --force":
+			result = ApplyNeedsForce
+		case strings.HasPrefix(line, "journal: ") && strings.Contains(line, "="):
+			fields := strings.SplitN(strings.TrimPrefix(line, "journal: "), "=", 2)
+			journal[fields[0]] = fields[1]
 		}
 	}
-	if showReport {
-		e.printApplyReport()
+	if err != nil && result != ApplyNeedsForce {
+		result = ApplyFailed
 	}
 
-	//if output was written, insert an empty line to preserve our own paragraph layout
-	if output.Len() > 0 {
-		outputBytes := output.Bytes()
-		os.Stdout.Write(outputBytes)
-		if bytes.HasSuffix(outputBytes, []byte("\n")) {
-			os.Stdout.Write([]byte("\n"))
-		} else {
-			os.Stdout.Write([]byte("\n\n"))
-		}
+	return result, journal, err
+}
+
+//applyOutput is used by Entity.Apply() and Entity.Rollback() to pass the plugin's output through to
+//the user, with the report header in front of it.
+type applyOutput struct {
+	entity        *Entity
+	action        string
+	mutex         sync.Mutex
+	headerPrinted bool
+	//the last byte of output (or 0 if there was no output yet)
+	lastByte byte
+	//copies of the output, for the Record returned by Apply()
+	stdoutCopy bytes.Buffer
+	stderrCopy bytes.Buffer
+}
+
+//Writer returns an io.Writer that writes into the given file, after printing
+//the report header if necessary. Writers for the same file compare equal, so
+//exec.Cmd will use a single pipe when they are used for both stdout and
+//stderr.
+func (o *applyOutput) Writer(file *os.File) io.Writer {
+	return applyOutputWriter{o, file}
+}
+
+type applyOutputWriter struct {
+	output *applyOutput
+	file   *os.File
+}
+
+func (w applyOutputWriter) Write(p []byte) (int, error) {
+	o := w.output
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !o.headerPrinted {
+		o.entity.printReportWithAction(o.action)
+		o.headerPrinted = true
 	}
+	o.lastByte = p[len(p)-1]
+	if w.file == os.Stderr {
+		o.stderrCopy.Write(p)
+	} else {
+		o.stdoutCopy.Write(p)
+	}
+	return w.file.Write(p)
+}
 
-	return err
+//isSameFile checks if both files refer to the same file (or terminal, or pipe).
+func isSameFile(file1, file2 *os.File) bool {
+	fi1, err := file1.Stat()
+	if err != nil {
+		return false
+	}
+	fi2, err := file2.Stat()
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi1, fi2)
+}
+
+//DiffOptions contains the options for RenderDiff() and DiffRecord(). They
+//are passed to the plugin in the environment variables $HOLO_DIFF_CONTEXT and
+//$HOLO_DIFF_FORMAT.
+type DiffOptions struct {
+	//number of unchanged lines shown around each change (or -1 for the default)
+	Context int
+	//"words" or "side-by-side" (or empty for the default unified diff)
+	Format string
+	//if true, the diff shows what the next `holo apply` would change instead
+	//of what was changed since the last `holo apply`
+	Pending bool
+}
+
+func (opts DiffOptions) environment() []string {
+	var env []string
+	if opts.Context >= 0 {
+		env = append(env, "HOLO_DIFF_CONTEXT="+strconv.Itoa(opts.Context))
+	}
+	if opts.Format != "" {
+		env = append(env, "HOLO_DIFF_FORMAT="+opts.Format)
+	}
+	return env
 }
 
 //RenderDiff creates a unified diff between the current and last
-//provisioned version of this entity.
-func (e *Entity) RenderDiff() ([]byte, error) {
-	var buffer bytes.Buffer
-	err := e.plugin.Command([]string{"diff", e.id}, &buffer, os.Stderr, nil).Run()
+//provisioned version of this entity (or, with opts.Pending, between the
+//current version and the version that the next `holo apply` would provision).
+func (e *Entity) RenderDiff(opts DiffOptions) ([]byte, error) {
+	return e.renderDiff(opts, os.Stderr)
+}
+
+//DiffRecord is like RenderDiff, but returns a Record that includes the diff
+//and any error output.
+func (e *Entity) DiffRecord(opts DiffOptions) *Record {
+	var stderr bytes.Buffer
+	output, err := e.renderDiff(opts, &stderr)
+
+	r := e.Record()
+	diff := string(output)
+	r.Diff = &diff
+	r.AddOutput(stderr.Bytes())
+	if err != nil {
+		r.AddError(err.Error())
+	}
+	return r
+}
+
+func (e *Entity) renderDiff(opts DiffOptions, stderr io.Writer) ([]byte, error) {
+	//plugins without the diff capability cannot produce a diff
+	operation := "diff"
+	if opts.Pending {
+		operation = "pending-diff"
+	}
+	if !e.plugin.HasCapability(operation) {
+		return nil, nil
+	}
+	var buffer, stderrCopy bytes.Buffer
+	cmd := e.plugin.Command([]string{operation, e.id}, &buffer, io.MultiWriter(stderr, &stderrCopy), nil)
+	cmd.Env = append(cmd.Env, opts.environment()...)
+	err := e.plugin.run(cmd)
+	if isReportedFailure(err, stderrCopy.Bytes()) {
+		err = reportedFailure{err}
+	}
 	return buffer.Bytes(), err
 }
@@ -0,0 +1,202 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//HistoryDirectory returns the path to the directory where the history of
+//`holo apply` runs is stored.
+func HistoryDirectory() string {
+	return filepath.Join(RootDirectory(), "var/lib/holo/history")
+}
+
+//HistoryRun describes a single `holo apply` run in the history.
+type HistoryRun struct {
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Command  []string       `json:"command"`
+	Entities []HistoryEntry `json:"entities"`
+}
+
+//HistoryEntry describes the outcome of the application algorithm for a single
+//entity in a HistoryRun.
+type HistoryEntry struct {
+	EntityID string            `json:"entity"`
+	PluginID string            `json:"plugin"`
+	Action   string            `json:"action"`
+	Result   ApplyResult       `json:"result"`
+	Warnings []string          `json:"warnings"`
+	Errors   []string          `json:"errors"`
+	Journal  map[string]string `json:"journal,omitempty"`
+}
+
+//NewHistoryRun prepares a HistoryRun for a `holo apply` run that was started
+//with the given command-line arguments.
+func NewHistoryRun(args []string) *HistoryRun {
+	return &HistoryRun{
+		Started:  time.Now().UTC(),
+		Command:  args,
+		Entities: []HistoryEntry{},
+	}
+}
+
+//Add adds the outcome of Entity.Apply() to the HistoryRun. Entities that were
+//not changed are not recorded.
+func (h *HistoryRun) Add(r *Record) {
+	if r.Result == nil || *r.Result == ApplyUnchanged {
+		return
+	}
+	h.Entities = append(h.Entities, HistoryEntry{
+		EntityID: r.EntityID,
+		PluginID: r.PluginID,
+		Action:   r.ActionVerb,
+		Result:   *r.Result,
+		Warnings: r.Warnings,
+		Errors:   r.Errors,
+		Journal:  r.Journal,
+	})
+}
+
+//Save writes the HistoryRun into the history directory, unless no entities
+//have been touched. Afterwards, the oldest runs are deleted such that at most
+//`keep` runs remain in the history.
+func (h *HistoryRun) Save(keep int) error {
+	if len(h.Entities) == 0 {
+		return nil
+	}
+	h.Finished = time.Now().UTC()
+
+	err := os.MkdirAll(HistoryDirectory(), 0755)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	//write into a temporary file first, so that a crash (or a full disk) does
+	//not leave a truncated file in the history
+	tempFile, err := ioutil.TempFile(HistoryDirectory(), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(append(data, '\n'))
+	if err == nil {
+		err = tempFile.Chmod(0644)
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	//the file names sort chronologically; the PID disambiguates runs that
+	//started at the same time
+	fileName := fmt.Sprintf("%s-%d.json", h.Started.Format("20060102T150405.000000Z"), os.Getpid())
+	err = os.Rename(tempFile.Name(), filepath.Join(HistoryDirectory(), fileName))
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	return pruneHistory(keep)
+}
+
+//historyFiles lists the names of the files in the history directory, in
+//chronological order.
+func historyFiles() ([]string, error) {
+	dir, err := os.Open(HistoryDirectory())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".json") {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+//pruneHistory deletes the oldest runs from the history directory, such that
+//at most `keep` runs remain.
+func pruneHistory(keep int) error {
+	names, err := historyFiles()
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		err := os.Remove(filepath.Join(HistoryDirectory(), names[0]))
+		if err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+//ReadHistory reads all runs from the history directory, in chronological
+//order. Files that cannot be parsed are skipped, and a warning is returned for
+//each of them.
+func ReadHistory() (runs []*HistoryRun, warnings []string, err error) {
+	names, err := historyFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range names {
+		path := filepath.Join(HistoryDirectory(), name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		var run HistoryRun
+		err = json.Unmarshal(data, &run)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping %s: cannot parse: %s", path, err.Error()))
+			continue
+		}
+		runs = append(runs, &run)
+	}
+	return runs, warnings, nil
+}
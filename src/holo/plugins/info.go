@@ -0,0 +1,112 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//The range of versions of the plugin API that Holo supports (see
+//holo-plugin-interface(7)).
+const (
+	minAPIVersion = 1
+	maxAPIVersion = 2
+)
+
+//negotiateAPIVersion runs the plugin's info operation to find out which API
+//versions and capabilities it supports, and chooses the highest API version
+//that both Holo and the plugin support. An error is returned if there is no
+//such version.
+func (p *Plugin) negotiateAPIVersion() error {
+	var stdout bytes.Buffer
+	err := p.run(p.Command([]string{"info"}, &stdout, ioutil.Discard, nil))
+	_, isExitError := err.(*exec.ExitError)
+	if isExitError || (err == nil && strings.TrimSpace(stdout.String()) == "") {
+		//plugins that were written for version 1 of the API do not know the
+		//info operation (and either fail or do nothing when it is called),
+		//but they all support the diff operation
+		p.apiVersion = 1
+		p.capabilities = map[string]bool{"diff": true}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	//parse info report
+	minVersion, maxVersion := 1, 1
+	capabilities := make(map[string]bool)
+	lineRx := regexp.MustCompile(`^\s*([^:]+): (.*?)\s*$`)
+	for idx, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		match := lineRx.FindStringSubmatch(line)
+		if match == nil {
+			return fmt.Errorf("error in info report, line %d: parse error (line was \"%s\")", idx+1, line)
+		}
+		key, value := match[1], match[2]
+
+		switch key {
+		case "MIN_API_VERSION", "MAX_API_VERSION":
+			version, err := strconv.Atoi(value)
+			if err != nil || version < 1 {
+				return fmt.Errorf("error in info report, line %d: invalid API version \"%s\"", idx+1, value)
+			}
+			if key == "MIN_API_VERSION" {
+				minVersion = version
+			} else {
+				maxVersion = version
+			}
+		case "CAPABILITIES":
+			for _, capability := range strings.Fields(value) {
+				capabilities[capability] = true
+			}
+		default:
+			//ignore unknown keys, for compatibility with future API versions
+		}
+	}
+
+	if minVersion > maxAPIVersion || maxVersion < minAPIVersion {
+		return fmt.Errorf(
+			"plugin supports API versions %d to %d, but this version of Holo only supports API versions %d to %d",
+			minVersion, maxVersion, minAPIVersion, maxAPIVersion,
+		)
+	}
+	p.apiVersion = maxVersion
+	if p.apiVersion > maxAPIVersion {
+		p.apiVersion = maxAPIVersion
+	}
+	p.capabilities = capabilities
+	return nil
+}
+
+//HasCapability checks if the plugin announced the given capability in its info
+//report (see holo-plugin-interface(7)).
+func (p *Plugin) HasCapability(capability string) bool {
+	return p.capabilities[capability]
+}
@@ -0,0 +1,156 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//lockFile is the file that is locked while Holo changes the system state. It
+//is only set while the lock is held.
+var lockFile *os.File
+
+//LockPath returns the path of the lock file that prevents simultaneous runs of
+//`holo apply`.
+func LockPath() string {
+	return filepath.Join(RootDirectory(), "var/lib/holo/lock")
+}
+
+//AcquireLock ensures that no other Holo process is changing the system state
+//at the same time. If another process holds the lock, an error naming its PID
+//is returned, or (if wait is true) AcquireLock waits until the lock is
+//released or Holo is interrupted.
+func AcquireLock(wait bool) error {
+	announcedWait := false
+	for {
+		holderPID, err := tryLock()
+		if err != nil || holderPID == 0 {
+			return err
+		}
+		if !wait {
+			return fmt.Errorf("another Holo process (%s) is currently running; use --wait to wait for it to finish", describeLockHolder(holderPID))
+		}
+		if !announcedWait {
+			fmt.Fprintf(os.Stderr, "Waiting for another Holo process (%s) to finish...\n", describeLockHolder(holderPID))
+			announcedWait = true
+		}
+		time.Sleep(100 * time.Millisecond)
+		if Interrupted() {
+			return fmt.Errorf("interrupted while waiting for another Holo process (%s)", describeLockHolder(holderPID))
+		}
+	}
+}
+
+//tryLock attempts to acquire the lock once. If another process holds the
+//lock, its PID is returned.
+func tryLock() (holderPID int, err error) {
+	path := LockPath()
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	//the lock is held with flock(2), so that it is released automatically when
+	//the process dies; the PID in the file is only informational
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return readLockHolder(path), nil
+	}
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+
+	//ReleaseLock() removes the lock file before unlocking, so check that we
+	//did not lock a file that has been removed in the meantime
+	fileInfo, err := file.Stat()
+	if err == nil {
+		var pathInfo os.FileInfo
+		pathInfo, err = os.Stat(path)
+		if err == nil && !os.SameFile(fileInfo, pathInfo) {
+			file.Close()
+			return tryLock()
+		}
+	}
+	if err != nil {
+		file.Close()
+		if os.IsNotExist(err) {
+			return tryLock()
+		}
+		return 0, err
+	}
+
+	//record our PID for the error message of other processes
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	lockFile = file
+	return 0, nil
+}
+
+//readLockHolder returns the PID recorded in the lock file (or -1 if it cannot
+//be read, e.g. because the other process has not written it yet).
+func readLockHolder(path string) int {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return -1
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return -1
+	}
+	return pid
+}
+
+func describeLockHolder(pid int) string {
+	if pid < 0 {
+		return "unknown PID"
+	}
+	return "PID " + strconv.Itoa(pid)
+}
+
+//ReleaseLock releases the lock acquired by AcquireLock(), if it is held.
+func ReleaseLock() {
+	if lockFile == nil {
+		return
+	}
+	//remove the file before unlocking it, so that no stale PID is left behind
+	_ = os.Remove(lockFile.Name()) //fail silently
+	lockFile.Close()               //this unlocks the file
+	lockFile = nil
+}
@@ -25,26 +25,38 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 //Plugin describes a plugin executable adhering to the holo-plugin-interface(7).
 type Plugin struct {
 	id             string
 	executablePath string
+	//additional environment variables (from holorc)
+	env []string
+	//maximum runtime of a plugin process for each operation (from holorc); the
+	//key "" contains the timeout for all other operations
+	timeouts map[string]time.Duration
+	//negotiated API version and capabilities (see negotiateAPIVersion(); an
+	//apiVersion of 0 means that negotiation has not happened yet)
+	apiVersion   int
+	capabilities map[string]bool
 }
 
 //NewPlugin creates a new Plugin.
 func NewPlugin(id string) *Plugin {
 	executablePath := filepath.Join(RootDirectory(), "usr/lib/holo/holo-"+id)
-	return &Plugin{id, executablePath}
+	return &Plugin{id: id, executablePath: executablePath, timeouts: make(map[string]time.Duration)}
 }
 
 //NewPluginWithExecutablePath creates a new Plugin whose executable resides in
 //a non-standard location. (This is used exclusively for testing plugins before
 //they are installed.)
 func NewPluginWithExecutablePath(id string, executablePath string) *Plugin {
-	return &Plugin{id, executablePath}
+	return &Plugin{id: id, executablePath: executablePath, timeouts: make(map[string]time.Duration)}
 }
 
 //ID returns the plugin ID.
@@ -83,13 +95,19 @@ func (p *Plugin) Command(arguments []string, stdout io.Writer, stderr io.Writer,
 	cmd.Stdin = nil
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
+	//put the plugin into its own process group, so that it can be killed
+	//together with its child processes (and does not receive SIGINT from the
+	//terminal, which Holo handles itself)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	if msg != nil {
 		cmd.ExtraFiles = []*os.File{msg}
 	}
 
 	//setup environment
-	env := os.Environ()
-	env = append(env, "HOLO_API_VERSION=1")
+	env := append(os.Environ(), p.env...)
+	if p.apiVersion > 0 {
+		env = append(env, "HOLO_API_VERSION="+strconv.Itoa(p.apiVersion))
+	}
 	env = append(env, "HOLO_CACHE_DIR="+normalizePath(p.CacheDirectory()))
 	env = append(env, "HOLO_RESOURCE_DIR="+normalizePath(p.ResourceDirectory()))
 	env = append(env, "HOLO_STATE_DIR="+normalizePath(p.StateDirectory()))
@@ -98,6 +116,34 @@ func (p *Plugin) Command(arguments []string, stdout io.Writer, stderr io.Writer,
 	return cmd
 }
 
+//timeoutFor returns the timeout for the given operation of this plugin (0
+//means no limit). The timeout for "apply" also applies to "force-apply",
+//"plan", "force-plan", "rollback" and "force-rollback", and the timeout for
+//"diff" also applies to "pending-diff" and "versions".
+func (p *Plugin) timeoutFor(operation string) time.Duration {
+	switch operation {
+	case "force-apply", "plan", "force-plan", "rollback", "force-rollback":
+		operation = "apply"
+	case "pending-diff", "versions":
+		operation = "diff"
+	}
+	if timeout, exists := p.timeouts[operation]; exists {
+		return timeout
+	}
+	return p.timeouts[""]
+}
+
+//isReservedEnvironmentVariable checks if the given environment variable is
+//set by Command() and thus cannot be set in holorc.
+func isReservedEnvironmentVariable(key string) bool {
+	switch key {
+	case "HOLO_API_VERSION", "HOLO_CACHE_DIR", "HOLO_RESOURCE_DIR", "HOLO_STATE_DIR":
+		return true
+	default:
+		return false
+	}
+}
+
 //For reproducibility in tests.
 func normalizePath(path string) string {
 	if path == "/" {
@@ -0,0 +1,213 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//runningProcesses contains all plugin processes that have been started, but
+//not waited for yet. Access is guarded by processMutex.
+var runningProcesses = make(map[*pluginProcess]bool)
+var processMutex sync.Mutex
+
+//interrupted is set when Holo receives SIGINT or SIGTERM. Access is guarded
+//by processMutex.
+var interrupted bool
+
+//HandleInterrupts sets up a handler for SIGINT and SIGTERM. When one of these
+//is received, all running plugins are killed, and no further plugins are
+//started. Use Interrupted() to find out if the remaining entities should be
+//skipped.
+func HandleInterrupts() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range signals {
+			processMutex.Lock()
+			interrupted = true
+			for process := range runningProcesses {
+				process.kill()
+			}
+			processMutex.Unlock()
+		}
+	}()
+}
+
+//Interrupted returns true if Holo has received SIGINT or SIGTERM.
+func Interrupted() bool {
+	processMutex.Lock()
+	defer processMutex.Unlock()
+	return interrupted
+}
+
+//killGracePeriod is how long a process has to exit after receiving SIGTERM
+//before it is killed with SIGKILL.
+const killGracePeriod = 5 * time.Second
+
+//pluginProcess represents a running plugin or hook process.
+type pluginProcess struct {
+	cmd *exec.Cmd
+	//description identifies the process in error messages, e.g. "plugin files"
+	//or "pre-apply hook".
+	description string
+	//operation is the plugin operation that is running (empty for hooks).
+	operation string
+	timeout   time.Duration
+	timer     *time.Timer
+	//timedOut is set by the timer. Access is guarded by processMutex.
+	timedOut bool
+	//killTimer sends SIGKILL when the grace period after SIGTERM has expired.
+	//Access is guarded by processMutex.
+	killTimer *time.Timer
+	//killed is set by the killTimer. Access is guarded by processMutex.
+	killed bool
+}
+
+//start starts a command that was prepared by Plugin.Command(). If a timeout
+//has been configured for this operation of the plugin, the plugin will be
+//terminated when the timeout expires.
+func (p *Plugin) start(cmd *exec.Cmd) (*pluginProcess, error) {
+	return startProcess(cmd, "plugin "+p.id, cmd.Args[1], p.timeoutFor(cmd.Args[1]))
+}
+
+//startProcess is the common implementation of Plugin.start() and RunHook().
+//The command must have been set up to run in its own process group.
+func startProcess(cmd *exec.Cmd, description, operation string, timeout time.Duration) (*pluginProcess, error) {
+	process := &pluginProcess{
+		cmd:         cmd,
+		description: description,
+		operation:   operation,
+		timeout:     timeout,
+	}
+
+	processMutex.Lock()
+	defer processMutex.Unlock()
+	if interrupted {
+		return nil, fmt.Errorf("not running %s: interrupted", description)
+	}
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	runningProcesses[process] = true
+
+	if process.timeout > 0 {
+		process.timer = time.AfterFunc(process.timeout, func() {
+			processMutex.Lock()
+			defer processMutex.Unlock()
+			process.timedOut = true
+			process.kill()
+		})
+	}
+	return process, nil
+}
+
+//run is like cmd.Run(), but observes timeouts and interrupts like start().
+func (p *Plugin) run(cmd *exec.Cmd) error {
+	process, err := p.start(cmd)
+	if err != nil {
+		return err
+	}
+	return process.wait()
+}
+
+//RunHook runs a hook command (as declared in holorc) with the given timeout
+//(0 means no limit). Like plugins, hooks run in their own process group, and
+//are terminated when the timeout expires or when Holo is interrupted. The
+//combined stdout and stderr of the hook is returned.
+func RunHook(stage, command string, env []string, timeout time.Duration) ([]byte, error) {
+	var output bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = env
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	process, err := startProcess(cmd, stage+" hook", "", timeout)
+	if err != nil {
+		return nil, err
+	}
+	err = process.wait()
+	return output.Bytes(), err
+}
+
+//wait waits for the process to exit. If it was terminated because of a
+//timeout or an interrupt, an error explaining this is returned.
+func (process *pluginProcess) wait() error {
+	err := process.cmd.Wait()
+	if process.timer != nil {
+		process.timer.Stop()
+	}
+
+	processMutex.Lock()
+	defer processMutex.Unlock()
+	if process.killTimer != nil {
+		process.killTimer.Stop()
+	}
+	delete(runningProcesses, process)
+
+	during := ""
+	if process.operation != "" {
+		during = fmt.Sprintf(" during %s operation", process.operation)
+	}
+	switch {
+	case process.timedOut && process.killed:
+		return fmt.Errorf("%s timed out after %s%s (killed after ignoring SIGTERM)",
+			process.description, process.timeout, during)
+	case process.timedOut:
+		return fmt.Errorf("%s timed out after %s%s (terminated)",
+			process.description, process.timeout, during)
+	case interrupted && err != nil:
+		return fmt.Errorf("%s was interrupted%s", process.description, during)
+	default:
+		return err
+	}
+}
+
+//kill terminates the process and all processes started by it (Plugin.Command()
+//and RunHook() put each process into its own process group) by sending
+//SIGTERM. If the process group has not exited after killGracePeriod, it is
+//killed with SIGKILL. The caller must hold processMutex.
+func (process *pluginProcess) kill() {
+	if process.cmd.Process == nil || process.killTimer != nil {
+		return
+	}
+	//the negative PID addresses the process group
+	pgid := -process.cmd.Process.Pid
+	syscall.Kill(pgid, syscall.SIGTERM)
+	process.killTimer = time.AfterFunc(killGracePeriod, func() {
+		processMutex.Lock()
+		defer processMutex.Unlock()
+		//do not signal a process group that might have been reused already
+		if runningProcesses[process] {
+			process.killed = true
+			syscall.Kill(pgid, syscall.SIGKILL)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type recordInfoLine struct {
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+}
+
+//Record is a machine-readable description of an Entity and (optionally) the
+//outcome of an operation on it. Records are printed instead of reports when
+//`--format=json` is given.
+type Record struct {
+	EntityID     string           `json:"entity"`
+	PluginID     string           `json:"plugin"`
+	ActionVerb   string           `json:"action"`
+	ActionReason string           `json:"reason,omitempty"`
+	InfoLines    []recordInfoLine `json:"info"`
+	Warnings     []string         `json:"warnings"`
+	Errors       []string         `json:"errors"`
+	//only set for `holo apply`
+	Changed *bool             `json:"changed,omitempty"`
+	Result  *ApplyResult      `json:"result,omitempty"`
+	Output  string            `json:"output,omitempty"`
+	Journal map[string]string `json:"journal,omitempty"`
+	//only set for `holo diff`
+	Diff *string `json:"diff,omitempty"`
+}
+
+//Record generates a Record describing this Entity.
+func (e *Entity) Record() *Record {
+	r := Record{
+		EntityID:     e.id,
+		PluginID:     e.plugin.ID(),
+		ActionVerb:   e.actionVerb,
+		ActionReason: e.actionReason,
+		InfoLines:    []recordInfoLine{},
+		Warnings:     []string{},
+		Errors:       []string{},
+	}
+	for _, infoLine := range e.infoLines {
+		r.InfoLines = append(r.InfoLines, recordInfoLine{infoLine.attribute, infoLine.value})
+	}
+	return &r
+}
+
+//AddError adds an error message to the given Record. If args... are given,
+//fmt.Sprintf() is applied.
+func (r *Record) AddError(text string, args ...interface{}) {
+	if len(args) > 0 {
+		text = fmt.Sprintf(text, args...)
+	}
+	r.Errors = append(r.Errors, text)
+}
+
+//AddOutput sorts the output of a plugin into the Record. Lines with the
+//conventional "!!" and ">>" prefixes are recorded as errors and warnings,
+//respectively. All other lines are appended to the Output field.
+func (r *Record) AddOutput(output []byte) {
+	text := strings.TrimSuffix(string(output), "\n")
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "!! "):
+			r.Errors = append(r.Errors, strings.TrimPrefix(line, "!! "))
+		case strings.HasPrefix(line, ">> "):
+			r.Warnings = append(r.Warnings, strings.TrimPrefix(line, ">> "))
+		default:
+			r.Output += line + "\n"
+		}
+	}
+}
+
+//Print prints the Record on stdout as a single line of JSON.
+func (r *Record) Print() {
+	err := json.NewEncoder(os.Stdout).Encode(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "!! %s\n", err.Error())
+	}
+}
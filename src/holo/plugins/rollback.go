@@ -0,0 +1,84 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package plugins
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//Version describes a previous version of an Entity that can be restored with
+//Entity.Rollback().
+type Version struct {
+	ID          string
+	Description string
+}
+
+//Versions asks the plugin for the previous versions of this Entity, oldest
+//first.
+func (e *Entity) Versions() ([]Version, error) {
+	if !e.plugin.HasCapability("rollback") {
+		return nil, fmt.Errorf("plugin %s does not support rollback", e.plugin.ID())
+	}
+	var stdout bytes.Buffer
+	err := e.plugin.run(e.plugin.Command([]string{"versions", e.id}, &stdout, os.Stderr, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		//each line looks like "ID: description"
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		versions = append(versions, Version{
+			ID:          strings.TrimSpace(fields[0]),
+			Description: strings.TrimSpace(fields[1]),
+		})
+	}
+	return versions, nil
+}
+
+//Rollback asks the plugin to restore the given previous version of this
+//Entity (or the most recent one, if version is empty). Like Apply, it prints
+//the plugin's output while it runs, and returns a Record describing the
+//outcome.
+func (e *Entity) Rollback(version string, withForce bool) *Record {
+	return e.streamOperation("Rolling back", func(stdout, stderr io.Writer) (ApplyResult, map[string]string, error) {
+		if !e.plugin.HasCapability("rollback") {
+			return ApplyFailed, nil, fmt.Errorf("plugin %s does not support rollback", e.plugin.ID())
+		}
+		command := "rollback"
+		if withForce {
+			command = "force-rollback"
+		}
+		args := []string{command, e.id}
+		if version != "" {
+			args = append(args, version)
+		}
+		return e.runWithCommandChannel(args, stdout, stderr)
+	})
+}
@@ -21,14 +21,20 @@
 package plugins
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 )
 
 var cachePath string
 
 func init() {
-	cachePath = filepath.Join(RootDirectory(), "tmp/holo-cache")
+	//each Holo process gets its own cache directory, so that concurrent runs
+	//(e.g. `holo scan` during `holo apply`) do not interfere with each other
+	cachePath = filepath.Join(RootDirectory(), fmt.Sprintf("tmp/holo-cache-%d", os.Getpid()))
 	err := doInit()
 	if err != nil {
 		r := Report{Action: "Errors occurred during", Target: "startup"}
@@ -39,22 +45,64 @@ func init() {
 }
 
 func doInit() error {
-	//if the cache directory exists from a previous run, remove it recursively
-	err := os.RemoveAll(cachePath)
-	if err != nil {
+	//if cache directories exist from previous runs that did not exit cleanly,
+	//remove them recursively
+	tmpPath := filepath.Dir(cachePath)
+	dir, err := os.Open(tmpPath)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if err == nil {
+		names, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if isStaleCacheDirectory(name) {
+				err := os.RemoveAll(filepath.Join(tmpPath, name))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
 
 	//create the cache directory
 	return os.MkdirAll(cachePath, 0700)
 }
 
+//isStaleCacheDirectory checks if the given directory name refers to a cache
+//directory of a Holo process that is not running anymore (or to the cache
+//directory of this process, which might be left over from a previous process
+//with the same PID).
+func isStaleCacheDirectory(name string) bool {
+	//the cache directory of older Holo versions
+	if name == "holo-cache" {
+		return true
+	}
+	if !strings.HasPrefix(name, "holo-cache-") {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimPrefix(name, "holo-cache-"))
+	if err != nil {
+		return false
+	}
+	if pid == os.Getpid() {
+		return true
+	}
+	//signal 0 only checks if the process exists (EPERM means that it exists,
+	//but belongs to another user)
+	err = syscall.Kill(pid, syscall.Signal(0))
+	return err == syscall.ESRCH
+}
+
 //CachePath returns the path below which plugin cache directories can be allocated.
 func CachePath() string {
 	return cachePath
 }
 
-//CleanupRuntimeCache tries to cleanup /tmp/holo-cache.
+//CleanupRuntimeCache tries to cleanup the cache directory of this process.
 func CleanupRuntimeCache() {
 	_ = os.RemoveAll(cachePath) //fail silently
 }
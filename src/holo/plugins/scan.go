@@ -29,23 +29,105 @@ import (
 	"strings"
 )
 
-//Scan discovers entities available for the given entity. Errors are reported
-//immediately and will result in nil being returned. "No entities found" will
-//be reported as a non-nil empty slice.
-//there are no entities.
-func (p *Plugin) Scan() []*Entity {
-	//invoke scan operation
-	stdout, hadError := p.runScanOperation()
-	if hadError {
-		return nil
+//ScanAll discovers entities available for the given plugins, with up to `jobs`
+//plugins scanning concurrently. Errors are reported in the order in which the
+//plugins are given, regardless of the order in which the scans finish, so the
+//output is deterministic. Fatal errors will result in nil being returned. "No
+//entities found" will be reported as a non-nil empty slice. If some plugin
+//reported non-fatal errors, hadErrors will be true. Plugins that are not
+//compatible with this version of Holo are skipped with a non-fatal error, so
+//that they do not block the other plugins.
+func ScanAll(plugins []*Plugin, jobs int) (entities []*Entity, hadErrors bool) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	//start the scan operations in a bounded number of worker goroutines; each
+	//output is delivered through its own channel
+	outputs := make([]chan scanOutput, len(plugins))
+	for idx := range plugins {
+		outputs[idx] = make(chan scanOutput, 1)
+	}
+	queue := make(chan int, len(plugins))
+	for idx := range plugins {
+		queue <- idx
+	}
+	close(queue)
+	for worker := 0; worker < jobs; worker++ {
+		go func() {
+			for idx := range queue {
+				outputs[idx] <- plugins[idx].runScanOperation()
+			}
+		}()
 	}
 
+	//collect results in plugin order
+	entities = []*Entity{}
+	for idx, plugin := range plugins {
+		output := <-outputs[idx]
+		pluginEntities, pluginHadErrors := plugin.parseScanOutput(output)
+		if output.incompatible {
+			hadErrors = true
+			continue
+		}
+		if pluginEntities == nil {
+			//NOTE: The remaining workers are abandoned. Their plugins will be
+			//killed when the process exits.
+			return nil, true
+		}
+		entities = append(entities, pluginEntities...)
+		hadErrors = hadErrors || pluginHadErrors
+	}
+	return entities, hadErrors
+}
+
+//scanOutput contains the results of running the plugin's scan operation.
+type scanOutput struct {
+	stdout []byte
+	stderr []byte
+	err    error
+	//incompatible is set when the API version negotiation failed (in which
+	//case the scan operation was not run)
+	incompatible bool
+}
+
+func (p *Plugin) runScanOperation() scanOutput {
+	err := p.negotiateAPIVersion()
+	if err != nil {
+		return scanOutput{err: err, incompatible: true}
+	}
+
+	var stdoutBuffer, stderrBuffer bytes.Buffer
+	err = p.run(p.Command([]string{"scan"}, &stdoutBuffer, &stderrBuffer, nil))
+	return scanOutput{stdout: stdoutBuffer.Bytes(), stderr: stderrBuffer.Bytes(), err: err}
+}
+
+//parseScanOutput reports errors from the scan operation and parses the scan
+//report. The return values are like for ScanAll().
+func (p *Plugin) parseScanOutput(output scanOutput) (entities []*Entity, hadErrors bool) {
+	//report any errors or error output
+	if output.err != nil || len(output.stderr) > 0 {
+		report := Report{Action: "scan with plugin", Target: p.ID()}
+		if output.err != nil {
+			report.AddError(output.err.Error())
+		}
+		report.Print()
+		if len(output.stderr) > 0 {
+			fmt.Fprintf(os.Stderr, "\n%s\n\n", strings.TrimSpace(string(output.stderr)))
+		}
+	}
+	if output.err != nil {
+		return nil, true
+	}
+	hadErrors = len(output.stderr) > 0
+	stdout := string(output.stdout)
+
 	//parse scan output
 	lines := strings.Split(strings.TrimSpace(stdout), "\n")
 	lineRx := regexp.MustCompile(`^\s*([^:]+): (.+)\s*$`)
 	actionRx := regexp.MustCompile(`^([^()]+) \((.+)\)$`)
 	report := Report{Action: "scan with plugin", Target: p.ID()}
-	hadError = false
+	hadError := false
 	var currentEntity *Entity
 	var result []*Entity
 	for idx, line := range lines {
@@ -88,6 +170,12 @@ func (p *Plugin) Scan() []*Entity {
 				currentEntity.actionVerb = match[1]
 				currentEntity.actionReason = match[2]
 			}
+		case key == "REQUIRES":
+			currentEntity.requires = append(currentEntity.requires, value)
+			currentEntity.infoLines = append(currentEntity.infoLines, InfoLine{"requires", value})
+		case key == "AFTER":
+			currentEntity.after = append(currentEntity.after, value)
+			currentEntity.infoLines = append(currentEntity.infoLines, InfoLine{"after", value})
 		default:
 			//store unrecognized keys as info lines
 			currentEntity.infoLines = append(currentEntity.infoLines,
@@ -104,7 +192,7 @@ func (p *Plugin) Scan() []*Entity {
 	//report errors
 	if hadError {
 		report.Print()
-		return nil
+		return nil, true
 	}
 
 	//on success, ensure non-nil return value
@@ -113,24 +201,7 @@ func (p *Plugin) Scan() []*Entity {
 	}
 
 	sort.Sort(entitiesByID(result))
-	return result
-}
-
-func (p *Plugin) runScanOperation() (stdout string, hadError bool) {
-	var stdoutBuffer, stderrBuffer bytes.Buffer
-	err := p.Command([]string{"scan"}, &stdoutBuffer, &stderrBuffer, nil).Run()
-
-	//report any errors or error output
-	if err != nil || stderrBuffer.Len() > 0 {
-		report := Report{Action: "scan with plugin", Target: p.ID()}
-		if err != nil {
-			report.AddError(err.Error())
-		}
-		report.Print()
-		fmt.Fprintf(os.Stderr, "\n%s\n\n", strings.TrimSpace(string(stderrBuffer.Bytes())))
-	}
-
-	return string(stdoutBuffer.Bytes()), err != nil
+	return result, hadErrors
 }
 
 type entitiesByID []*Entity
@@ -0,0 +1,127 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"./plugins"
+)
+
+//entitySelection describes which entities have been selected on the command
+//line.
+type entitySelection struct {
+	//Patterns contains the entity IDs and patterns given as positional
+	//arguments. If empty, all entities are selected.
+	Patterns []string
+	//PluginIDs contains the arguments of the --plugin options. If not empty,
+	//only entities provided by these plugins are selected.
+	PluginIDs []string
+	//Excludes contains the arguments of the --exclude options. Entities
+	//matching any of these patterns are not selected.
+	Excludes []string
+}
+
+//filter returns those of the given entities which are selected, in the same
+//order.
+func (s entitySelection) filter(entities []*plugins.Entity) []*plugins.Entity {
+	result := make([]*plugins.Entity, 0, len(entities))
+	for _, entity := range entities {
+		if s.selects(entity) {
+			result = append(result, entity)
+		}
+	}
+	return result
+}
+
+func (s entitySelection) selects(entity *plugins.Entity) bool {
+	id := entity.EntityID()
+	if len(s.Patterns) > 0 && !matchesAnyPattern(id, s.Patterns) {
+		return false
+	}
+	if len(s.PluginIDs) > 0 && !containsString(s.PluginIDs, entity.PluginID()) {
+		return false
+	}
+	return !matchesAnyPattern(id, s.Excludes)
+}
+
+//matchesEntityPattern checks if the given pattern selects the given entity ID.
+//The pattern may be an exact entity ID or a shell glob as understood by
+//filepath.Match (e.g. "user:*" or "/etc/nginx/*"). Like in .gitignore files,
+//the pattern also selects an entity if it selects one of its parent
+//directories, so "/etc/nginx" selects the whole subtree below /etc/nginx.
+func matchesEntityPattern(id, pattern string) bool {
+	if len(pattern) > 1 {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	for {
+		if id == pattern {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, id); ok {
+			return true
+		}
+		parent := filepath.Dir(id)
+		if parent == id || parent == "." {
+			return false
+		}
+		id = parent
+	}
+}
+
+func matchesAnyPattern(id string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchesEntityPattern(id, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+//selectsAnyEntity checks if the given pattern selects at least one of the
+//given entities.
+func selectsAnyEntity(pattern string, entities []*plugins.Entity) bool {
+	for _, entity := range entities {
+		if matchesEntityPattern(entity.EntityID(), pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPluginID(id string, pluginList []*plugins.Plugin) bool {
+	for _, plugin := range pluginList {
+		if plugin.ID() == id {
+			return true
+		}
+	}
+	return false
+}
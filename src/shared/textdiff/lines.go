@@ -0,0 +1,150 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package textdiff
+
+import (
+	"bytes"
+	"strings"
+)
+
+//SplitLines splits file contents into lines. Each line keeps its trailing
+//newline character, so that joining the lines gives the original contents.
+func SplitLines(contents string) []string {
+	var lines []string
+	for contents != "" {
+		idx := strings.IndexByte(contents, '\n')
+		if idx == -1 {
+			lines = append(lines, contents)
+			break
+		}
+		lines = append(lines, contents[:idx+1])
+		contents = contents[idx+1:]
+	}
+	return lines
+}
+
+//IsBinary uses the same heuristic as Git to decide whether the given file
+//contents are binary (and thus cannot be shown in a diff): Binary files
+//contain a NUL byte within their first few kilobytes.
+func IsBinary(contents []byte) bool {
+	if len(contents) > 8000 {
+		contents = contents[:8000]
+	}
+	return bytes.IndexByte(contents, 0) != -1
+}
+
+//MatchLines computes the longest common subsequence of the given lists of
+//lines with the algorithm by Eugene W. Myers ("An O(ND) Difference Algorithm
+//and Its Variations", 1986). For each line of a, the result contains the index
+//of the matching line in b, or -1 if the line is not part of the common
+//subsequence (i.e. if it was removed on the way from a to b).
+func MatchLines(a, b []string) []int {
+	result := make([]int, len(a))
+	for idx := range result {
+		result[idx] = -1
+	}
+
+	//the common prefix and suffix are always part of the result, and usually
+	//make up most of the files, so skip them before running the expensive
+	//part of the algorithm
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		result[prefix] = prefix
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		result[len(a)-1-suffix] = len(b) - 1 - suffix
+		suffix++
+	}
+
+	middle := matchMyers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	for idx, match := range middle {
+		if match != -1 {
+			result[prefix+idx] = prefix + match
+		}
+	}
+	return result
+}
+
+func matchMyers(a, b []string) []int {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	//find the shortest edit script, and keep the relevant part of the
+	//intermediate states of v (the diagonals -d-1..d+1 before step d) for the
+	//backtracking below
+	var trace [][]int
+	finalD := 0
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] //move down (insertion)
+			} else {
+				x = v[offset+k-1] + 1 //move right (deletion)
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				finalD = d
+				break search
+			}
+		}
+	}
+
+	//backtrack to find the matching lines
+	result := make([]int, n)
+	for idx := range result {
+		result[idx] = -1
+	}
+	x, y := n, m
+	for d := finalD; d > 0; d-- {
+		v := trace[d]
+		vOffset := d + 1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[vOffset+k-1] < v[vOffset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[vOffset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			result[x] = y
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		result[x] = y
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+//Package textdiff renders differences between text files in the unified diff
+//format (and some variations thereof), without relying on external programs
+//like diff(1) or git-diff(1).
+package textdiff
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+//Format selects how Render() presents the changes within each hunk.
+type Format string
+
+const (
+	//FormatUnified is the usual unified diff format, as produced by `diff -u`.
+	FormatUnified Format = "unified"
+	//FormatWords highlights the changed words within lines, similar to `git
+	//diff --word-diff`.
+	FormatWords Format = "words"
+	//FormatSideBySide shows the old and new lines next to each other, similar
+	//to `diff -y`.
+	FormatSideBySide Format = "side-by-side"
+)
+
+//DefaultContext is the number of unchanged lines that are shown around each
+//change, unless specified otherwise.
+const DefaultContext = 3
+
+//Options controls the output of Render().
+type Options struct {
+	//Context is the number of unchanged lines that are shown around each
+	//change.
+	Context int
+	Format  Format
+}
+
+//DefaultOptions returns the options for a plain unified diff.
+func DefaultOptions() Options {
+	return Options{Context: DefaultContext, Format: FormatUnified}
+}
+
+//OptionsFromEnvironment returns the options that the user selected for `holo
+//diff`. Holo passes them to plugins in the environment variables
+//$HOLO_DIFF_CONTEXT and $HOLO_DIFF_FORMAT. Unset variables select the
+//defaults.
+func OptionsFromEnvironment() (Options, error) {
+	opts := DefaultOptions()
+
+	if value := os.Getenv("HOLO_DIFF_CONTEXT"); value != "" {
+		context, err := strconv.Atoi(value)
+		if err != nil || context < 0 {
+			return opts, fmt.Errorf("invalid value for $HOLO_DIFF_CONTEXT: \"%s\"", value)
+		}
+		opts.Context = context
+	}
+
+	switch value := Format(os.Getenv("HOLO_DIFF_FORMAT")); value {
+	case "":
+		//keep default
+	case FormatUnified, FormatWords, FormatSideBySide:
+		opts.Format = value
+	default:
+		return opts, fmt.Errorf("invalid value for $HOLO_DIFF_FORMAT: \"%s\"", value)
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,323 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package textdiff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+//block is a range of changed lines: the lines from[FromStart:FromEnd] were
+//replaced by the lines to[ToStart:ToEnd]. In a hunk, the same type describes
+//the range of lines covered by the hunk (including context lines).
+type block struct {
+	FromStart, FromEnd int
+	ToStart, ToEnd     int
+}
+
+//Render renders the differences between the given lists of lines (as returned
+//by SplitLines) as a list of hunks, in the format selected by the given
+//options. The result does not include any file headers (the "---" and "+++"
+//lines), and is empty if there are no differences.
+func Render(from, to []string, opts Options) []byte {
+	var buffer bytes.Buffer
+	for _, hunk := range groupHunks(findChanges(from, to), len(from), opts.Context) {
+		writeHunkHeader(&buffer, from, hunk.Bounds)
+		switch opts.Format {
+		case FormatWords:
+			writeWordsHunk(&buffer, from, to, hunk)
+		case FormatSideBySide:
+			writeSideBySideHunk(&buffer, from, to, hunk)
+		default:
+			writeUnifiedHunk(&buffer, from, to, hunk)
+		}
+	}
+	return buffer.Bytes()
+}
+
+//findChanges returns the blocks of changed lines between the given lists of
+//lines, in order.
+func findChanges(from, to []string) []block {
+	//mark lines that are not part of the longest common subsequence
+	fromChanged := make([]bool, len(from))
+	toChanged := make([]bool, len(to))
+	for idx := range toChanged {
+		toChanged[idx] = true
+	}
+	for fromIdx, toIdx := range MatchLines(from, to) {
+		if toIdx == -1 {
+			fromChanged[fromIdx] = true
+		} else {
+			toChanged[toIdx] = false
+		}
+	}
+	compactChanges(from, fromChanged, toChanged)
+	compactChanges(to, toChanged, fromChanged)
+
+	//the unchanged lines of both sides correspond to each other in order, so
+	//the blocks can be found by walking through both sides in parallel
+	var blocks []block
+	fromIdx, toIdx := 0, 0
+	for fromIdx < len(from) || toIdx < len(to) {
+		if fromIdx < len(from) && toIdx < len(to) && !fromChanged[fromIdx] && !toChanged[toIdx] {
+			fromIdx++
+			toIdx++
+			continue
+		}
+		b := block{FromStart: fromIdx, ToStart: toIdx}
+		for fromIdx < len(from) && fromChanged[fromIdx] {
+			fromIdx++
+		}
+		for toIdx < len(to) && toChanged[toIdx] {
+			toIdx++
+		}
+		b.FromEnd, b.ToEnd = fromIdx, toIdx
+		blocks = append(blocks, b)
+	}
+	return blocks
+}
+
+//compactChanges moves groups of changed lines up or down where this does not
+//change the meaning of the diff, using the same rules as git: Each group is
+//moved as far down as possible, unless it can be aligned with a group of
+//changed lines in the other file on the way (which makes replacements appear
+//as such). When the same line is added repeatedly, the LCS algorithm may mark
+//any of the copies as added, so this step makes the output predictable.
+func compactChanges(lines []string, changed, otherChanged []bool) {
+	g := firstGroup(changed)
+	other := firstGroup(otherChanged)
+	for {
+		if g.End != g.Start {
+			var earliestEnd, endMatchingOther int
+			for {
+				size := g.End - g.Start
+				endMatchingOther = -1
+				for g.slideUp(lines, changed) {
+					other.previous(otherChanged)
+				}
+				earliestEnd = g.End
+				if other.End > other.Start {
+					endMatchingOther = g.End
+				}
+				for g.slideDown(lines, changed) {
+					other.next(otherChanged)
+					if other.End > other.Start {
+						endMatchingOther = g.End
+					}
+				}
+				//if the group absorbed a neighboring group, start over
+				if size == g.End-g.Start {
+					break
+				}
+			}
+			if g.End != earliestEnd && endMatchingOther != -1 {
+				for other.End == other.Start {
+					g.slideUp(lines, changed)
+					other.previous(otherChanged)
+				}
+			}
+		}
+		if !g.next(changed) {
+			break
+		}
+		other.next(otherChanged)
+	}
+}
+
+//group is a maximal range of changed lines (which may be empty). Between each
+//pair of neighboring groups, there is exactly one unchanged line.
+type group struct {
+	Start, End int
+}
+
+func firstGroup(changed []bool) group {
+	end := 0
+	for end < len(changed) && changed[end] {
+		end++
+	}
+	return group{0, end}
+}
+
+func (g *group) next(changed []bool) bool {
+	if g.End == len(changed) {
+		return false
+	}
+	g.Start = g.End + 1
+	g.End = g.Start
+	for g.End < len(changed) && changed[g.End] {
+		g.End++
+	}
+	return true
+}
+
+func (g *group) previous(changed []bool) bool {
+	if g.Start == 0 {
+		return false
+	}
+	g.End = g.Start - 1
+	g.Start = g.End
+	for g.Start > 0 && changed[g.Start-1] {
+		g.Start--
+	}
+	return true
+}
+
+//slideDown moves the group down by one line if the line below the group is
+//identical to the first line of the group.
+func (g *group) slideDown(lines []string, changed []bool) bool {
+	if g.End == len(lines) || lines[g.Start] != lines[g.End] {
+		return false
+	}
+	changed[g.Start] = false
+	changed[g.End] = true
+	g.Start++
+	g.End++
+	//the group may now touch the next group, and absorb it
+	for g.End < len(changed) && changed[g.End] {
+		g.End++
+	}
+	return true
+}
+
+//slideUp moves the group up by one line if the line above the group is
+//identical to the last line of the group.
+func (g *group) slideUp(lines []string, changed []bool) bool {
+	if g.Start == 0 || lines[g.Start-1] != lines[g.End-1] {
+		return false
+	}
+	g.Start--
+	g.End--
+	changed[g.Start] = true
+	changed[g.End] = false
+	//the group may now touch the previous group, and absorb it
+	for g.Start > 0 && changed[g.Start-1] {
+		g.Start--
+	}
+	return true
+}
+
+//hunk is a group of change blocks that are shown together, because they are
+//close enough that their context lines would overlap.
+type hunk struct {
+	Bounds block
+	Blocks []block
+}
+
+func groupHunks(blocks []block, fromLength, context int) []hunk {
+	var hunks []hunk
+	for _, b := range blocks {
+		//merge with the previous hunk if the context lines would overlap or
+		//touch (the number of unchanged lines between two blocks is the same
+		//on both sides)
+		if len(hunks) > 0 {
+			last := &hunks[len(hunks)-1]
+			prev := last.Blocks[len(last.Blocks)-1]
+			if b.FromStart-prev.FromEnd <= 2*context {
+				last.Blocks = append(last.Blocks, b)
+				continue
+			}
+		}
+		hunks = append(hunks, hunk{Blocks: []block{b}})
+	}
+
+	//add context lines
+	for idx := range hunks {
+		h := &hunks[idx]
+		first, last := h.Blocks[0], h.Blocks[len(h.Blocks)-1]
+		before := minInt(context, first.FromStart)
+		after := minInt(context, fromLength-last.FromEnd)
+		h.Bounds = block{
+			FromStart: first.FromStart - before,
+			FromEnd:   last.FromEnd + after,
+			ToStart:   first.ToStart - before,
+			ToEnd:     last.ToEnd + after,
+		}
+	}
+	return hunks
+}
+
+//writeHunkHeader writes the "@@ -1,3 +1,4 @@" line for the given hunk. Like
+//git, the last line before the hunk that looks like the start of a section is
+//shown after the line ranges (a line that starts with a letter, "_" or "$").
+func writeHunkHeader(buffer *bytes.Buffer, from []string, bounds block) {
+	fmt.Fprintf(buffer, "@@ -%s +%s @@",
+		formatRange(bounds.FromStart, bounds.FromEnd),
+		formatRange(bounds.ToStart, bounds.ToEnd),
+	)
+	for idx := bounds.FromStart - 1; idx >= 0; idx-- {
+		line := from[idx]
+		if line == "" {
+			continue
+		}
+		c := line[0]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '_' || c == '$' {
+			if len(line) > 80 {
+				line = line[:80]
+			}
+			buffer.WriteString(" " + strings.TrimRight(line, " \t\r\n\v\f"))
+			break
+		}
+	}
+	buffer.WriteString("\n")
+}
+
+func formatRange(start, end int) string {
+	switch end - start {
+	case 0:
+		//an empty range is identified by the line before it
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, end-start)
+	}
+}
+
+func writeUnifiedHunk(buffer *bytes.Buffer, from, to []string, h hunk) {
+	fromIdx, toIdx := h.Bounds.FromStart, h.Bounds.ToStart
+	for _, b := range append(h.Blocks, block{FromStart: h.Bounds.FromEnd, FromEnd: h.Bounds.FromEnd}) {
+		for ; fromIdx < b.FromStart; fromIdx, toIdx = fromIdx+1, toIdx+1 {
+			writeUnifiedLine(buffer, ' ', from[fromIdx])
+		}
+		for ; fromIdx < b.FromEnd; fromIdx++ {
+			writeUnifiedLine(buffer, '-', from[fromIdx])
+		}
+		for ; toIdx < b.ToEnd; toIdx++ {
+			writeUnifiedLine(buffer, '+', to[toIdx])
+		}
+	}
+}
+
+func writeUnifiedLine(buffer *bytes.Buffer, prefix byte, line string) {
+	buffer.WriteByte(prefix)
+	buffer.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		buffer.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
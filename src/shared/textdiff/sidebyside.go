@@ -0,0 +1,102 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package textdiff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//maxColumnWidth limits the width of the left column in the side-by-side
+//format. Longer lines are not truncated, but they push the right column away.
+const maxColumnWidth = 60
+
+//writeSideBySideHunk writes the body of a hunk in a format similar to `diff
+//-y`: The old lines are shown on the left, the new lines on the right, and the
+//column between them shows whether a line was changed ("|"), removed ("<") or
+//added (">").
+func writeSideBySideHunk(buffer *bytes.Buffer, from, to []string, h hunk) {
+	type row struct {
+		Left, Marker, Right string
+	}
+	var rows []row
+
+	fromIdx, toIdx := h.Bounds.FromStart, h.Bounds.ToStart
+	for _, b := range append(h.Blocks, block{FromStart: h.Bounds.FromEnd, FromEnd: h.Bounds.FromEnd}) {
+		for ; fromIdx < b.FromStart; fromIdx, toIdx = fromIdx+1, toIdx+1 {
+			rows = append(rows, row{from[fromIdx], " ", to[toIdx]})
+		}
+		for fromIdx < b.FromEnd || toIdx < b.ToEnd {
+			switch {
+			case fromIdx < b.FromEnd && toIdx < b.ToEnd:
+				rows = append(rows, row{from[fromIdx], "|", to[toIdx]})
+				fromIdx++
+				toIdx++
+			case fromIdx < b.FromEnd:
+				rows = append(rows, row{from[fromIdx], "<", ""})
+				fromIdx++
+			default:
+				rows = append(rows, row{"", ">", to[toIdx]})
+				toIdx++
+			}
+		}
+	}
+
+	width := 0
+	for idx := range rows {
+		rows[idx].Left = expandTabs(strings.TrimSuffix(rows[idx].Left, "\n"))
+		rows[idx].Right = expandTabs(strings.TrimSuffix(rows[idx].Right, "\n"))
+		if w := utf8.RuneCountInString(rows[idx].Left); w > width {
+			width = w
+		}
+	}
+	if width > maxColumnWidth {
+		width = maxColumnWidth
+	}
+
+	for _, r := range rows {
+		line := fmt.Sprintf("%-*s %s %s", width, r.Left, r.Marker, r.Right)
+		buffer.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+}
+
+//expandTabs replaces tabs by spaces (with tab stops every 8 columns), so that
+//the columns stay aligned.
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var result bytes.Buffer
+	column := 0
+	for _, r := range line {
+		if r == '\t' {
+			spaces := 8 - column%8
+			result.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+		} else {
+			result.WriteRune(r)
+			column++
+		}
+	}
+	return result.String()
+}
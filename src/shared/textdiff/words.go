@@ -0,0 +1,129 @@
+/*******************************************************************************
+*
+* Copyright 2015 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package textdiff
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+)
+
+//writeWordsHunk writes the body of a hunk in the format of `git diff
+//--word-diff=plain`: Unchanged lines are shown as they are, and within changed
+//lines, removed words are marked as [-word-] and added words as {+word+}.
+func writeWordsHunk(buffer *bytes.Buffer, from, to []string, h hunk) {
+	fromIdx := h.Bounds.FromStart
+	for _, b := range append(h.Blocks, block{FromStart: h.Bounds.FromEnd, FromEnd: h.Bounds.FromEnd}) {
+		for ; fromIdx < b.FromStart; fromIdx++ {
+			buffer.WriteString(withNewline(from[fromIdx]))
+		}
+		if b.FromStart != b.FromEnd || b.ToStart != b.ToEnd {
+			writeWordsBlock(buffer,
+				splitWords(strings.Join(from[b.FromStart:b.FromEnd], "")),
+				splitWords(strings.Join(to[b.ToStart:b.ToEnd], "")),
+			)
+		}
+		fromIdx = b.FromEnd
+	}
+}
+
+func writeWordsBlock(buffer *bytes.Buffer, fromWords, toWords []string) {
+	var out bytes.Buffer
+	var removed, added string
+	flush := func() {
+		writeMarkedWords(&out, "[-", "-]", removed)
+		writeMarkedWords(&out, "{+", "+}", added)
+		removed, added = "", ""
+	}
+
+	match := MatchLines(fromWords, toWords)
+	fromIdx, toIdx := 0, 0
+	for fromIdx < len(fromWords) || toIdx < len(toWords) {
+		switch {
+		case fromIdx < len(fromWords) && match[fromIdx] == -1:
+			removed += fromWords[fromIdx]
+			fromIdx++
+		case fromIdx == len(fromWords) || match[fromIdx] != toIdx:
+			added += toWords[toIdx]
+			toIdx++
+		default:
+			flush()
+			out.WriteString(toWords[toIdx])
+			fromIdx++
+			toIdx++
+		}
+	}
+	flush()
+
+	buffer.WriteString(withNewline(out.String()))
+}
+
+//writeMarkedWords writes the given text enclosed in the given markers. The
+//markers are repeated on each line if the text spans multiple lines.
+func writeMarkedWords(out *bytes.Buffer, open, close, text string) {
+	for idx, segment := range strings.Split(text, "\n") {
+		if idx > 0 {
+			out.WriteByte('\n')
+		}
+		if segment != "" {
+			out.WriteString(open + segment + close)
+		}
+	}
+}
+
+//splitWords splits the given text into words, runs of whitespace, and line
+//breaks (each of which is a separate token). Joining the tokens gives the
+//original text.
+func splitWords(text string) []string {
+	var tokens []string
+	start := 0
+	//0 = line break, 1 = whitespace, 2 = other
+	class := func(r rune) int {
+		switch {
+		case r == '\n':
+			return 0
+		case unicode.IsSpace(r):
+			return 1
+		default:
+			return 2
+		}
+	}
+	lastClass := -1
+	for idx, r := range text {
+		c := class(r)
+		if idx > start && (c != lastClass || c == 0) {
+			tokens = append(tokens, text[start:idx])
+			start = idx
+		}
+		lastClass = c
+	}
+	if start < len(text) {
+		tokens = append(tokens, text[start:])
+	}
+	return tokens
+}
+
+func withNewline(line string) string {
+	if strings.HasSuffix(line, "\n") {
+		return line
+	}
+	return line + "\n"
+}
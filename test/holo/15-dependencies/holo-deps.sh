@@ -0,0 +1,14 @@
+#!/bin/sh
+# mock plugin for the dependency ordering test
+case "$1" in
+    scan)
+        cat "$HOLO_RESOURCE_DIR/scan-report"
+        ;;
+    apply|force-apply|plan|force-plan)
+        if [ "$2" = dep:broken ]; then
+            echo "!! cannot provision $2" >&2
+            exit 1
+        fi
+        echo "provisioning $2"
+        ;;
+esac
@@ -0,0 +1,11 @@
+#!/bin/sh
+# mock plugin for the holorc directives test
+case "$1" in
+    scan)
+        echo "ENTITY: mock:greeting"
+        echo "greeting: $GREETING"
+        ;;
+    apply|force-apply)
+        echo "$GREETING from the mock plugin"
+        ;;
+esac
@@ -0,0 +1,20 @@
+#!/bin/sh
+# mock plugin for the API negotiation test (API versions 1 to 3)
+case "$1" in
+    info)
+        echo "MIN_API_VERSION: 1"
+        echo "MAX_API_VERSION: 3"
+        echo "CAPABILITIES: plan"
+        ;;
+    scan)
+        echo "ENTITY: new:entity"
+        echo "api version: $HOLO_API_VERSION"
+        ;;
+    apply)
+        echo "applying with API version $HOLO_API_VERSION"
+        ;;
+    *)
+        echo "unexpected operation: $1" >&2
+        exit 1
+        ;;
+esac
@@ -0,0 +1,18 @@
+#!/bin/sh
+# mock plugin for the API negotiation test (API version 1 only)
+case "$1" in
+    scan)
+        echo "ENTITY: old:entity"
+        echo "api version: $HOLO_API_VERSION"
+        ;;
+    apply)
+        echo "applying with API version $HOLO_API_VERSION"
+        ;;
+    diff)
+        echo "diff with API version $HOLO_API_VERSION"
+        ;;
+    *)
+        echo "unknown operation: $1" >&2
+        exit 1
+        ;;
+esac
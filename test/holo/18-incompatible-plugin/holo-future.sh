@@ -0,0 +1,12 @@
+#!/bin/sh
+# mock plugin for the API negotiation test (API versions 5 and 6 only)
+case "$1" in
+    info)
+        echo "MIN_API_VERSION: 5"
+        echo "MAX_API_VERSION: 6"
+        ;;
+    *)
+        echo "unexpected operation: $1" >&2
+        exit 1
+        ;;
+esac
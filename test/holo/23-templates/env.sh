@@ -0,0 +1,3 @@
+#!/bin/sh
+export HOLO_CURRENT_HOSTNAME=testhost
+export HOLO_CURRENT_DISTRIBUTION=arch
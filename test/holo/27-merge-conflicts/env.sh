@@ -0,0 +1,2 @@
+#!/bin/sh
+export HOLO_CURRENT_DISTRIBUTION=arch
@@ -0,0 +1,2 @@
+#!/bin/sh
+export HOLO_DIFF_CONTEXT=1
@@ -0,0 +1,3 @@
+#!/bin/sh
+export HOLO_CURRENT_HOSTNAME=web-01
+export HOLO_CURRENT_ARCHITECTURE=x86_64
@@ -0,0 +1,27 @@
+#!/bin/sh
+# mock plugin for the timeouts test
+case "$1" in
+    scan)
+        echo "ENTITY: mock:graceful"
+        echo "ENTITY: mock:stubborn"
+        echo "ENTITY: mock:hooked"
+        ;;
+    apply|force-apply)
+        case "$2" in
+            mock:graceful)
+                # exits when it receives SIGTERM
+                trap 'echo "cleaning up after SIGTERM"; exit 1' TERM
+                sleep 30 &
+                wait
+                ;;
+            mock:stubborn)
+                # ignores SIGTERM (and so does the sleep), so only SIGKILL helps
+                trap '' TERM
+                sleep 30
+                ;;
+            mock:hooked)
+                echo "never reached since the pre-apply hook times out"
+                ;;
+        esac
+        ;;
+esac
@@ -5,19 +5,31 @@ _holo() {
 
     if [ "$COMP_CWORD" = 1 ]; then
         # autocomplete first argument (either a command verb or --help/--version)
-        COMPREPLY=( $(compgen -W "--help --version apply diff scan" -- "$CURRENT_WORD") )
+        COMPREPLY=( $(compgen -W "--help --version apply check diff history rollback scan" -- "$CURRENT_WORD") )
         return 0
     elif [ "${COMP_WORDS[1]}" = "apply" ]; then
-        # autocomplete for "holo apply" - argument is either an entity or -f/--force
-        COMPREPLY=( $(compgen -W "$(holo scan --short) -f --force" -- "$CURRENT_WORD") )
+        # autocomplete for "holo apply" - argument is either an entity or an option
+        COMPREPLY=( $(compgen -W "$(holo scan --short) -f --force -n --dry-run --wait --format=json --plugin --exclude" -- "$CURRENT_WORD") )
+        return 0
+    elif [ "${COMP_WORDS[1]}" = "check" ]; then
+        # autocomplete for "holo check" - argument is either an entity or an option
+        COMPREPLY=( $(compgen -W "$(holo scan --short) -f --force --format=json --plugin --exclude" -- "$CURRENT_WORD") )
         return 0
     elif [ "${COMP_WORDS[1]}" = "diff" ]; then
-        # autocomplete for "holo diff" - argument is an entity
-        COMPREPLY=( $(compgen -W "$(holo scan --short)" -- "$CURRENT_WORD") )
+        # autocomplete for "holo diff" - argument is an entity or an option
+        COMPREPLY=( $(compgen -W "$(holo scan --short) --pending -U --unified --word-diff --side-by-side --format=json --plugin --exclude" -- "$CURRENT_WORD") )
+        return 0
+    elif [ "${COMP_WORDS[1]}" = "history" ]; then
+        # autocomplete for "holo history" - argument is an entity or --format=json
+        COMPREPLY=( $(compgen -W "$(holo scan --short) --format=json" -- "$CURRENT_WORD") )
+        return 0
+    elif [ "${COMP_WORDS[1]}" = "rollback" ]; then
+        # autocomplete for "holo rollback" - argument is either an entity or an option
+        COMPREPLY=( $(compgen -W "$(holo scan --short) -f --force -l --list --wait --to --plugin --exclude" -- "$CURRENT_WORD") )
         return 0
     elif [ "${COMP_WORDS[1]}" = "scan" ]; then
-        # autocomplete for "holo scan" - argument is either an entity or -s/--short
-        COMPREPLY=( $(compgen -W "$(holo scan --short) -s --short" -- "$CURRENT_WORD") )
+        # autocomplete for "holo scan" - argument is either an entity or an option
+        COMPREPLY=( $(compgen -W "$(holo scan --short) -s --short --format=json --plugin --exclude" -- "$CURRENT_WORD") )
         return 0
     fi
 }